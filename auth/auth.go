@@ -0,0 +1,316 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+var (
+	// ErrNoCredentials is returned by an Authenticator when the request does not carry credentials it understands.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials is returned by an Authenticator when the request carries credentials that don't match.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Role defines what a caller is allowed to do.  Each role includes the permissions of the roles before it.
+type Role uint8
+
+const (
+	// Anonymous callers can't do anything.
+	Anonymous Role = iota
+	// Viewer can read the current state, e.g. a wall mounted dashboard.
+	Viewer
+	// Editor can also change modes and the schedule.
+	Editor
+	// Admin can do everything, including controlling the HVAC system directly.
+	Admin
+)
+
+func (r Role) String() string {
+	switch r {
+	case Viewer:
+		return "viewer"
+	case Editor:
+		return "editor"
+	case Admin:
+		return "admin"
+	default:
+		return "anonymous"
+	}
+}
+
+func (r Role) MarshalText() (text []byte, err error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Role) UnmarshalText(text []byte) error {
+	switch strings.ToLower(string(text)) {
+	case "viewer":
+		*r = Viewer
+	case "editor":
+		*r = Editor
+	case "admin":
+		*r = Admin
+	case "anonymous", "":
+		*r = Anonymous
+	default:
+		return fmt.Errorf("unknown role %q", text)
+	}
+	return nil
+}
+
+// Principal is an authenticated caller.
+type Principal struct {
+	Name string
+	Role Role
+}
+
+// Authenticator identifies the caller of a request.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Config defines who may access an HTTP API.
+type Config struct {
+	// Tokens are accepted as "Authorization: Bearer <token>" and are stored as hex encoded SHA-256 hashes.
+	Tokens []Token `json:"tokens"`
+	// Users are accepted through HTTP basic authentication and their passwords are stored as bcrypt hashes.
+	Users []User `json:"users"`
+	// Certificates grant roles to clients presenting a verified TLS client certificate with the given common name.
+	Certificates []Certificate `json:"certificates"`
+	// AnonymousRole is granted to requests without credentials.
+	AnonymousRole Role `json:"anonymousRole"`
+	// CORSOrigins lists the origins browsers may call the API from, "*" allows any.
+	CORSOrigins []string `json:"corsOrigins"`
+}
+
+// Token is an API token for a script or device.
+type Token struct {
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+	Role Role   `json:"role"`
+}
+
+// Certificate is a device authenticating with a TLS client certificate, e.g. the thermostat calling the controller.
+type Certificate struct {
+	CommonName string `json:"commonName"`
+	Role       Role   `json:"role"`
+}
+
+// User is a person logging in with a password.
+type User struct {
+	Name         string `json:"name"`
+	PasswordHash string `json:"passwordHash"`
+	Role         Role   `json:"role"`
+}
+
+// Enabled reports whether any credentials have been configured.
+func (c *Config) Enabled() bool {
+	return c != nil && (len(c.Tokens) > 0 || len(c.Users) > 0 || len(c.Certificates) > 0)
+}
+
+// NewAuthenticator builds an Authenticator that accepts any of the tokens and users in config.  Without any credentials
+// configured every caller is treated as an Admin so existing installations keep working.
+func NewAuthenticator(config *Config) Authenticator {
+	if !config.Enabled() {
+		return &Static{Principal: &Principal{Name: "anonymous", Role: Admin}}
+	}
+
+	return &Chain{
+		Authenticators: []Authenticator{
+			&CertificateAuthenticator{Certificates: config.Certificates},
+			&TokenAuthenticator{Tokens: config.Tokens},
+			&BasicAuthenticator{Users: config.Users},
+		},
+		Anonymous: &Principal{Name: "anonymous", Role: config.AnonymousRole},
+	}
+}
+
+// Static authenticates every request as the same principal.
+type Static struct {
+	Principal *Principal
+}
+
+// Authenticate implements Authenticator.
+func (s *Static) Authenticate(r *http.Request) (*Principal, error) {
+	return s.Principal, nil
+}
+
+// Chain tries each of its Authenticators in turn and falls back to the Anonymous principal if none of them found
+// credentials.
+type Chain struct {
+	Authenticators []Authenticator
+	Anonymous      *Principal
+}
+
+// Authenticate implements Authenticator.
+func (c *Chain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, authn := range c.Authenticators {
+		principal, err := authn.Authenticate(r)
+		if err == ErrNoCredentials {
+			continue
+		}
+		return principal, err
+	}
+	return c.Anonymous, nil
+}
+
+// TokenAuthenticator accepts bearer tokens.
+type TokenAuthenticator struct {
+	Tokens []Token
+}
+
+// Authenticate implements Authenticator.
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return nil, ErrNoCredentials
+	}
+
+	sum := sha256.Sum256([]byte(strings.TrimSpace(header[7:])))
+	hash := hex.EncodeToString(sum[:])
+	for _, token := range a.Tokens {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(strings.ToLower(token.Hash))) == 1 {
+			return &Principal{Name: token.Name, Role: token.Role}, nil
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// CertificateAuthenticator accepts TLS client certificates that were verified during the handshake.
+type CertificateAuthenticator struct {
+	Certificates []Certificate
+}
+
+// Authenticate implements Authenticator.
+func (a *CertificateAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(a.Certificates) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	commonName := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	for _, cert := range a.Certificates {
+		if cert.CommonName == commonName {
+			return &Principal{Name: commonName, Role: cert.Role}, nil
+		}
+	}
+	// the certificate is trusted but has no role here, let other credentials decide
+	return nil, ErrNoCredentials
+}
+
+// BasicAuthenticator accepts HTTP basic authentication.
+type BasicAuthenticator struct {
+	Users []User
+}
+
+// Authenticate implements Authenticator.
+func (a *BasicAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	for _, user := range a.Users {
+		if user.Name != name {
+			continue
+		}
+		if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+			break
+		}
+		return &Principal{Name: user.Name, Role: user.Role}, nil
+	}
+	return nil, ErrInvalidCredentials
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying principal.
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the principal that Require stored in ctx, if any.
+func PrincipalFrom(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// Require wraps handler so that it is only served to callers with at least the role that required returns for the
+// request.  The authenticated Principal is available to handler through PrincipalFrom.
+func Require(authn Authenticator, required func(*http.Request) Role, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, err := authn.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="thermostat"`)
+			util.WriteError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		if role := required(r); principal.Role < role {
+			if principal.Role == Anonymous {
+				w.Header().Set("WWW-Authenticate", `Basic realm="thermostat"`)
+				util.WriteError(w, http.StatusUnauthorized, "authentication required")
+				return
+			}
+			util.WriteError(w, http.StatusForbidden, fmt.Sprintf("%s role required", role))
+			return
+		}
+
+		handler(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	}
+}
+
+// QueryToken lets callers that can't set headers, like a browser's EventSource, pass a bearer token as the access_token
+// query parameter instead.  Only wrap routes that need it: URLs end up in logs and browser history.
+func QueryToken(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+			r = r.Clone(r.Context())
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		handler(w, r)
+	}
+}
+
+// Always requires role for every request.
+func Always(role Role) func(*http.Request) Role {
+	return func(*http.Request) Role {
+		return role
+	}
+}
+
+// ReadWrite requires read for safe methods (GET, HEAD, OPTIONS) and write for everything else.
+func ReadWrite(read, write Role) func(*http.Request) Role {
+	return func(r *http.Request) Role {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return read
+		default:
+			return write
+		}
+	}
+}
+
+// AllowedOrigin returns the value of the Access-Control-Allow-Origin header for a request from origin, or an empty
+// string if that origin isn't in allowed.
+func AllowedOrigin(allowed []string, origin string) string {
+	for _, o := range allowed {
+		if o == "*" {
+			return "*"
+		}
+		if origin != "" && strings.EqualFold(o, origin) {
+			return origin
+		}
+	}
+	return ""
+}
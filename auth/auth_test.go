@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRequire(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("dashboard-token"))
+
+	authn := NewAuthenticator(&Config{
+		Tokens: []Token{{Name: "dashboard", Hash: hex.EncodeToString(sum[:]), Role: Viewer}},
+		Users:  []User{{Name: "parent", PasswordHash: string(hash), Role: Editor}},
+	})
+
+	var caller *Principal
+	handler := Require(authn, ReadWrite(Viewer, Editor), func(w http.ResponseWriter, r *http.Request) {
+		caller = PrincipalFrom(r.Context())
+	})
+
+	tests := []struct {
+		method, user, password, token string
+		status                        int
+	}{
+		{method: http.MethodGet, status: http.StatusUnauthorized},
+		{method: http.MethodGet, token: "dashboard-token", status: http.StatusOK},
+		{method: http.MethodPost, token: "dashboard-token", status: http.StatusForbidden},
+		{method: http.MethodGet, token: "wrong", status: http.StatusUnauthorized},
+		{method: http.MethodPost, user: "parent", password: "hunter2", status: http.StatusOK},
+		{method: http.MethodPost, user: "parent", password: "wrong", status: http.StatusUnauthorized},
+		{method: http.MethodPost, user: "stranger", password: "hunter2", status: http.StatusUnauthorized},
+	}
+
+	for i, test := range tests {
+		r := httptest.NewRequest(test.method, "/", nil)
+		if test.token != "" {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		if test.user != "" {
+			r.SetBasicAuth(test.user, test.password)
+		}
+		w := httptest.NewRecorder()
+
+		caller = nil
+		handler(w, r)
+		if w.Code != test.status {
+			t.Errorf("Request #%d: expected status %d, got %d.", i+1, test.status, w.Code)
+		}
+		if test.status == http.StatusOK && caller == nil {
+			t.Errorf("Request #%d: principal not passed to handler.", i+1)
+		}
+		if test.status != http.StatusOK {
+			resp := new(struct{ Error string })
+			if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil || resp.Error == "" {
+				t.Errorf("Request #%d: expected a JSON error, got %q.", i+1, w.Body.String())
+			}
+		}
+	}
+}
+
+func TestNoCredentialsConfigured(t *testing.T) {
+	principal, err := NewAuthenticator(&Config{}).Authenticate(httptest.NewRequest(http.MethodPost, "/", nil))
+	if err != nil || principal.Role != Admin {
+		t.Error("Without configured credentials everyone should be an Admin.")
+	}
+}
+
+func TestAllowedOrigin(t *testing.T) {
+	allowed := []string{"http://tablet.local"}
+	if AllowedOrigin(allowed, "http://tablet.local") != "http://tablet.local" {
+		t.Error("Configured origin was not allowed.")
+	}
+	if AllowedOrigin(allowed, "http://evil.example") != "" {
+		t.Error("Unknown origin was allowed.")
+	}
+	if AllowedOrigin([]string{"*"}, "http://evil.example") != "*" {
+		t.Error("Wildcard origin was not allowed.")
+	}
+}
@@ -1,22 +1,30 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/ghodss/yaml"
 	"github.com/stianeikeland/go-rpio"
 
+	"github.com/alittlebrighter/thermostat/auth"
 	"github.com/alittlebrighter/thermostat/controller"
+	"github.com/alittlebrighter/thermostat/util"
 )
 
-const DEFAULT_CONFIG = "/etc/thermostat.conf"
+const (
+	DEFAULT_CONFIG   = "/etc/thermostat.conf"
+	DEFAULT_STATE    = "/var/lib/thermostat/controller-state.json"
+	SHUTDOWN_TIMEOUT = 10 * time.Second
+)
 
 func main() {
 	configFile := flag.String("config", DEFAULT_CONFIG, "The configuration file for the controller.")
@@ -41,6 +49,22 @@ func main() {
 		fanCooldown = 1 * time.Minute
 	}
 
+	var minOff time.Duration
+	if config.MinOff != "" {
+		if minOff, err = time.ParseDuration(config.MinOff); err != nil {
+			log.Fatalln("ERROR: Could not parse minOff!\n" + err.Error())
+		}
+	}
+
+	if config.StateFile == "" {
+		config.StateFile = DEFAULT_STATE
+	}
+
+	tlsConfig, err := config.TLS.ServerConfig()
+	if err != nil {
+		log.Fatalln("ERROR: Could not load TLS certificates!\n" + err.Error())
+	}
+
 	log.Println("Setting up controller.")
 	control, err := controller.NewCentralController(config.Pins.Heat, config.Pins.Cool, config.Pins.Fan, fanCooldown)
 	if err != nil {
@@ -50,64 +74,222 @@ func main() {
 	defer control.Shutdown()
 	defer control.Off()
 
-	appCtx := &appContext{control}
+	ctx, stop := util.SignalContext(context.Background())
+	defer stop()
+
+	appCtx := &appContext{hvacControl: control, started: time.Now(), stop: stop, stateFile: config.StateFile, minOff: minOff}
+	appCtx.recover()
 
-	http.HandleFunc("/heat", appCtx.controlElement("HEAT", appCtx.hvacControl.Heat))
-	http.HandleFunc("/cool", appCtx.controlElement("AC", appCtx.hvacControl.Cool))
-	http.HandleFunc("/fan", appCtx.controlElement("FAN", appCtx.hvacControl.Fan))
+	if !config.Auth.Enabled() {
+		log.Println("WARNING: No API tokens or users configured, anyone on the network can control the HVAC system.")
+	} else if config.Auth.AnonymousRole == auth.Admin {
+		log.Println("WARNING: anonymousRole is admin, anyone on the network can control the HVAC system without credentials.")
+	}
+	authn := auth.NewAuthenticator(&config.Auth)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/state", auth.Require(authn, auth.ReadWrite(auth.Viewer, auth.Admin), appCtx.State))
+	mux.HandleFunc("/shutdown", auth.Require(authn, auth.Always(auth.Admin), appCtx.Shutdown))
+	server := &http.Server{Addr: config.ServeAt, Handler: mux}
+	server.TLSConfig = tlsConfig
 
-	log.Println("Starting web server at " + config.ServeAt)
-	log.Fatal(http.ListenAndServe(config.ServeAt, nil))
+	go func() {
+		log.Println("Starting web server at " + config.ServeAt)
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			log.Println("ERROR: Web server stopped!\n" + err.Error())
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("ERROR: Could not stop web server cleanly!\n" + err.Error())
+	}
+	appCtx.saveState(true)
+	log.Println("Controller stopped.")
+}
+
+// hvacController is what the API needs of a controller, a CentralController in production.
+type hvacController interface {
+	controller.Controller
+	PinLevels() controller.PinLevels
+	FanCoolingDown() bool
 }
 
 type appContext struct {
-	hvacControl controller.Controller
+	hvacControl hvacController
+	started     time.Time
+	stop        context.CancelFunc
+	stateFile   string
+	minOff      time.Duration
+
+	mu                sync.Mutex
+	since             time.Time
+	restingUntil      time.Time
+	unexpectedRestart bool
 }
 
-func (appCtx *appContext) controlElement(elementName string, turnOn func()) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		resp := new(response)
-
-		if strings.ToUpper(r.Method) == http.MethodPost {
-			command := &response{Errors: []string{}}
-			err := json.NewDecoder(r.Body).Decode(command)
-			switch {
-			case err != nil:
-				log.Println("ERROR: " + err.Error())
-				resp.Errors = append(resp.Errors, err.Error())
-			case command.ElementOn:
-				log.Println("Turning " + elementName + " ON.")
-				turnOn()
-			case !command.ElementOn:
-				log.Println("Turning " + elementName + " OFF.")
-				appCtx.hvacControl.Off()
-			}
+// recover reconciles the controller with the state it was in before the process last stopped.  Heating and cooling
+// stay off for at least minOff since they may have only just been cut while the fan is turned back on.
+func (appCtx *appContext) recover() {
+	appCtx.mu.Lock()
+	defer appCtx.mu.Unlock()
+
+	now := time.Now()
+	appCtx.since = now
+
+	previous := new(controller.State)
+	err := util.ReadJSONFile(appCtx.stateFile, previous)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		log.Println("ERROR: Could not read controller state!\n" + err.Error())
+	default:
+		if !previous.CleanShutdown {
+			log.Printf("WARNING: Unexpected restart, was %s since %v.", previous.Direction, previous.Since)
+			appCtx.unexpectedRestart = true
 		}
 
-		resp.ElementOn = appCtx.hvacControl.Direction() == controller.Heating
+		switch previous.Direction {
+		case controller.Heating, controller.Cooling:
+			appCtx.restingUntil = now.Add(appCtx.minOff)
+		case controller.Fan:
+			log.Println("Resuming FAN.")
+			appCtx.hvacControl.Fan()
+			appCtx.since = previous.Since
+		}
+	}
+
+	appCtx.saveStateLocked(false)
+}
+
+func (appCtx *appContext) saveState(cleanShutdown bool) {
+	appCtx.mu.Lock()
+	defer appCtx.mu.Unlock()
+	appCtx.saveStateLocked(cleanShutdown)
+}
 
-		w.WriteHeader(http.StatusOK)
-		err := json.NewEncoder(w).Encode(resp)
-		if err != nil {
-			log.Println("ERROR: " + err.Error())
+func (appCtx *appContext) saveStateLocked(cleanShutdown bool) {
+	state := &controller.State{Direction: appCtx.hvacControl.Direction(), Since: appCtx.since, CleanShutdown: cleanShutdown}
+	if err := util.WriteJSONFile(appCtx.stateFile, state); err != nil {
+		log.Println("ERROR: Could not save controller state!\n" + err.Error())
+	}
+}
+
+// State reports the current state of the HVAC system on GET and changes its direction on PUT.
+func (appCtx *appContext) State(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		command := new(stateCommand)
+		if err := json.NewDecoder(r.Body).Decode(command); err != nil {
+			util.WriteError(w, http.StatusBadRequest, "could not parse command: "+err.Error())
+			return
+		}
+		if command.Direction == nil {
+			util.WriteError(w, http.StatusBadRequest, "direction is required")
+			return
 		}
+
+		if status, err := appCtx.changeDirection(*command.Direction); err != nil {
+			util.WriteError(w, status, err.Error())
+			return
+		}
+	default:
+		util.MethodNotAllowed(w, r, "GET, PUT")
+		return
+	}
+
+	appCtx.mu.Lock()
+	resp := &state{
+		Direction:         appCtx.hvacControl.Direction(),
+		Since:             appCtx.since,
+		Pins:              appCtx.hvacControl.PinLevels(),
+		FanCoolingDown:    appCtx.hvacControl.FanCoolingDown(),
+		Uptime:            util.Duration(time.Since(appCtx.started)),
+		UnexpectedRestart: appCtx.unexpectedRestart,
+	}
+	if time.Now().Before(appCtx.restingUntil) {
+		resp.RestingUntil = &appCtx.restingUntil
 	}
+	appCtx.mu.Unlock()
+
+	util.WriteJSON(w, http.StatusOK, resp)
 }
 
+func (appCtx *appContext) changeDirection(direction controller.ThermoDirection) (int, error) {
+	appCtx.mu.Lock()
+	defer appCtx.mu.Unlock()
+
+	previous := appCtx.hvacControl.Direction()
+	if previous == direction {
+		return http.StatusOK, nil
+	}
+	if (direction == controller.Heating || direction == controller.Cooling) && time.Now().Before(appCtx.restingUntil) {
+		return http.StatusConflict, fmt.Errorf("minimum off time not reached, %s not allowed until %s", direction, appCtx.restingUntil.Format(time.RFC3339))
+	}
+
+	log.Println("Changing direction to " + direction.String() + ".")
+	switch direction {
+	case controller.Heating:
+		appCtx.hvacControl.Heat()
+	case controller.Cooling:
+		appCtx.hvacControl.Cool()
+	case controller.Fan:
+		appCtx.hvacControl.Fan()
+	default:
+		appCtx.hvacControl.Off()
+	}
+
+	appCtx.since = time.Now()
+	if previous == controller.Heating || previous == controller.Cooling {
+		appCtx.restingUntil = appCtx.since.Add(appCtx.minOff)
+	}
+	appCtx.saveStateLocked(false)
+	return http.StatusOK, nil
+}
+
+// Shutdown stops the server, after which all HVAC components are turned off and the GPIOs are released.
 func (appCtx *appContext) Shutdown(w http.ResponseWriter, r *http.Request) {
-	if strings.ToUpper(r.Method) == http.MethodPost {
-		appCtx.hvacControl.Shutdown()
-		os.Exit(0)
+	if r.Method != http.MethodPost {
+		util.MethodNotAllowed(w, r, "POST")
+		return
 	}
+
+	log.Println("Shutdown requested.")
+	w.WriteHeader(http.StatusAccepted)
+	appCtx.stop()
+}
+
+type state struct {
+	Direction         controller.ThermoDirection `json:"direction"`
+	Since             time.Time                  `json:"since"`
+	Pins              controller.PinLevels       `json:"pins"`
+	FanCoolingDown    bool                       `json:"fanCoolingDown"`
+	RestingUntil      *time.Time                 `json:"restingUntil,omitempty"`
+	Uptime            util.Duration              `json:"uptime"`
+	UnexpectedRestart bool                       `json:"unexpectedRestart"`
 }
 
-type response struct {
-	ElementOn bool
-	Errors    []string
+type stateCommand struct {
+	Direction *controller.ThermoDirection `json:"direction"`
 }
 
 type controllerConfig struct {
 	ServeAt     string
 	Pins        struct{ Fan, Cool, Heat int }
 	FanCooldown string
+	MinOff      string
+	StateFile   string
+	Auth        auth.Config
+	TLS         util.TLSConfig
 }
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alittlebrighter/thermostat/controller"
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+type fakeController struct {
+	direction controller.ThermoDirection
+	shutdown  bool
+}
+
+func (c *fakeController) Direction() controller.ThermoDirection { return c.direction }
+func (c *fakeController) Off()                                  { c.direction = controller.None }
+func (c *fakeController) Fan()                                  { c.direction = controller.Fan }
+func (c *fakeController) Cool()                                 { c.direction = controller.Cooling }
+func (c *fakeController) Heat()                                 { c.direction = controller.Heating }
+func (c *fakeController) Shutdown()                             { c.shutdown = true }
+func (c *fakeController) FanCoolingDown() bool                  { return false }
+
+func (c *fakeController) PinLevels() controller.PinLevels {
+	return controller.PinLevels{
+		Fan:  c.direction != controller.None,
+		Heat: c.direction == controller.Heating,
+		Cool: c.direction == controller.Cooling,
+	}
+}
+
+func newTestApp(t *testing.T, minOff time.Duration) (*appContext, *fakeController, func()) {
+	dir, err := ioutil.TempDir("", "hvac-controller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	control := new(fakeController)
+	appCtx := &appContext{hvacControl: control, started: time.Now(), stop: func() {}, stateFile: filepath.Join(dir, "state.json"), minOff: minOff}
+	return appCtx, control, func() { os.RemoveAll(dir) }
+}
+
+func request(appCtx *appContext, handler http.HandlerFunc, method, body string) (*httptest.ResponseRecorder, *state) {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(method, "/state", strings.NewReader(body)))
+	resp := new(state)
+	if w.Code == http.StatusOK {
+		json.Unmarshal(w.Body.Bytes(), resp)
+	}
+	return w, resp
+}
+
+func TestState(t *testing.T) {
+	appCtx, control, cleanup := newTestApp(t, time.Hour)
+	defer cleanup()
+	appCtx.recover()
+
+	if w, resp := request(appCtx, appCtx.State, http.MethodGet, ""); w.Code != http.StatusOK || resp.Direction != controller.None {
+		t.Errorf("Expected the system off, got %d %+v", w.Code, resp)
+	}
+
+	w, resp := request(appCtx, appCtx.State, http.MethodPut, `{"direction": "heating"}`)
+	if w.Code != http.StatusOK || control.direction != controller.Heating || !resp.Pins.Heat {
+		t.Errorf("Expected heating, got %d %+v", w.Code, resp)
+	}
+	saved := new(controller.State)
+	if err := util.ReadJSONFile(appCtx.stateFile, saved); err != nil || saved.Direction != controller.Heating {
+		t.Errorf("Expected the state saved, got %+v, %v", saved, err)
+	}
+
+	if w, resp = request(appCtx, appCtx.State, http.MethodPut, `{"direction": "off"}`); w.Code != http.StatusOK || resp.RestingUntil == nil {
+		t.Errorf("Expected the minimum off time to start, got %d %+v", w.Code, resp)
+	}
+	if w, _ = request(appCtx, appCtx.State, http.MethodPut, `{"direction": "cooling"}`); w.Code != http.StatusConflict || control.direction != controller.None {
+		t.Errorf("Expected cooling refused within the minimum off time, got %d", w.Code)
+	}
+	if w, _ = request(appCtx, appCtx.State, http.MethodPut, `{"direction": "fan"}`); w.Code != http.StatusOK || control.direction != controller.Fan {
+		t.Errorf("Expected the fan allowed within the minimum off time, got %d", w.Code)
+	}
+
+	for _, body := range []string{`{}`, `{"direction": "sideways"}`, `not json`} {
+		if w, _ = request(appCtx, appCtx.State, http.MethodPut, body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s rejected, got %d", body, w.Code)
+		}
+	}
+	if w, _ = request(appCtx, appCtx.State, http.MethodDelete, ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected DELETE not allowed, got %d", w.Code)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	appCtx, control, cleanup := newTestApp(t, time.Hour)
+	defer cleanup()
+
+	since := time.Now().Add(-time.Minute).Round(time.Second)
+	if err := util.WriteJSONFile(appCtx.stateFile, &controller.State{Direction: controller.Fan, Since: since}); err != nil {
+		t.Fatal(err)
+	}
+	appCtx.recover()
+	if control.direction != controller.Fan || !appCtx.since.Equal(since) || !appCtx.unexpectedRestart {
+		t.Errorf("Expected the fan resumed after an unexpected restart, got %s since %v", control.direction, appCtx.since)
+	}
+
+	appCtx, control, cleanup = newTestApp(t, time.Hour)
+	defer cleanup()
+	if err := util.WriteJSONFile(appCtx.stateFile, &controller.State{Direction: controller.Heating, Since: since, CleanShutdown: true}); err != nil {
+		t.Fatal(err)
+	}
+	appCtx.recover()
+	if control.direction != controller.None || appCtx.unexpectedRestart || !appCtx.restingUntil.After(time.Now()) {
+		t.Errorf("Expected heating to rest after a clean shutdown, got %s until %v", control.direction, appCtx.restingUntil)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	appCtx, _, cleanup := newTestApp(t, 0)
+	defer cleanup()
+	stopped := false
+	appCtx.stop = func() { stopped = true }
+
+	if w, _ := request(appCtx, appCtx.Shutdown, http.MethodGet, ""); w.Code != http.StatusMethodNotAllowed || stopped {
+		t.Errorf("Expected GET not allowed, got %d", w.Code)
+	}
+	if w, _ := request(appCtx, appCtx.Shutdown, http.MethodPost, ""); w.Code != http.StatusAccepted || !stopped {
+		t.Errorf("Expected the server stopped, got %d", w.Code)
+	}
+}
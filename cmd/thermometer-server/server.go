@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/alittlebrighter/thermostat"
+	"github.com/alittlebrighter/thermostat/auth"
+	"github.com/alittlebrighter/thermostat/thermometer"
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+const (
+	DEFAULT_CONFIG   = "/etc/thermometer.conf"
+	SHUTDOWN_TIMEOUT = 10 * time.Second
+)
+
+func main() {
+	configFile := flag.String("config", DEFAULT_CONFIG, "The configuration file for the thermometer server.")
+	flag.Parse()
+
+	data, err := ioutil.ReadFile(*configFile)
+	if err != nil {
+		log.Fatalln("ERROR: Could not read configuration file!\n" + err.Error())
+	}
+
+	config := new(serverConfig)
+	if err = yaml.Unmarshal(data, config); err != nil {
+		log.Fatalln("ERROR: Could not parse configuration!\n" + err.Error())
+	}
+
+	tlsConfig, err := config.TLS.ServerConfig()
+	if err != nil {
+		log.Fatalln("ERROR: Could not load TLS certificates!\n" + err.Error())
+	}
+
+	log.Println("Setting up thermometer.")
+	if config.Thermometer.Type == "" {
+		config.Thermometer.Type = "local"
+	}
+	meter, err := config.Thermometer.NewThermometer()
+	if err != nil {
+		log.Fatalln("ERROR: Cannot start thermometer!\n" + err.Error())
+	}
+	sampler := thermometer.NewSampler(meter, &config.Sampling)
+	defer sampler.Shutdown()
+
+	ctx, stop := util.SignalContext(context.Background())
+	defer stop()
+
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		sampler.Run(ctx)
+	}()
+
+	if !config.Auth.Enabled() {
+		log.Println("WARNING: No API tokens or users configured, anyone on the network can read the thermometer.")
+	} else if config.Auth.AnonymousRole == auth.Admin {
+		log.Println("WARNING: anonymousRole is admin, anyone on the network can read the thermometer without credentials.")
+	}
+	authn := auth.NewAuthenticator(&config.Auth)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/temperature", auth.Require(authn, auth.Always(auth.Viewer), TemperatureHandlerFactory(sampler)))
+	mux.HandleFunc("/health", auth.Require(authn, auth.Always(auth.Viewer), HealthHandlerFactory(sampler)))
+	server := &http.Server{Addr: config.ServeAt, Handler: mux}
+	server.TLSConfig = tlsConfig
+
+	go func() {
+		log.Println("Starting web server at " + config.ServeAt)
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			log.Println("ERROR: Web server stopped!\n" + err.Error())
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("ERROR: Could not stop web server cleanly!\n" + err.Error())
+	}
+	<-sampled
+	log.Println("Thermometer stopped.")
+}
+
+// TemperatureHandlerFactory serves GET /temperature, the current reading in the form JSONWebService expects.  Sensor
+// errors are reported in the reading rather than the status code.  Clients that only understand the legacy format
+// can ask for it with ?version=1.
+func TemperatureHandlerFactory(sampler *thermometer.Sampler) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			util.MethodNotAllowed(w, r, "GET")
+			return
+		}
+
+		reading := sampler.Reading()
+		switch r.URL.Query().Get("version") {
+		case "", strconv.Itoa(thermometer.WireVersion):
+			util.WriteJSON(w, http.StatusOK, reading)
+		case "1":
+			util.WriteJSON(w, http.StatusOK, reading.Legacy())
+		default:
+			util.WriteError(w, http.StatusBadRequest, "unsupported version "+r.URL.Query().Get("version"))
+		}
+	}
+}
+
+// HealthHandlerFactory serves GET /health, responding 503 while there is no recent reading.
+func HealthHandlerFactory(sampler *thermometer.Sampler) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			util.MethodNotAllowed(w, r, "GET")
+			return
+		}
+
+		health := sampler.Health()
+		status := http.StatusOK
+		if !health.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+		util.WriteJSON(w, status, health)
+	}
+}
+
+type serverConfig struct {
+	ServeAt     string
+	Thermometer thermostat.ThermometerConfig
+	Sampling    thermometer.SamplerConfig
+	Auth        auth.Config
+	TLS         util.TLSConfig
+}
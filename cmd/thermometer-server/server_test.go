@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alittlebrighter/thermostat/thermometer"
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+type fakeThermometer struct {
+	temp util.Temperature
+	err  error
+}
+
+func (m *fakeThermometer) ReadTemperature() (util.Temperature, error) { return m.temp, m.err }
+func (m *fakeThermometer) Shutdown()                                  {}
+
+// newTestSampler returns a sampler that has read meter once.
+func newTestSampler(meter thermometer.Thermometer) *thermometer.Sampler {
+	sampler := thermometer.NewSampler(meter, &thermometer.SamplerConfig{SensorID: "hallway"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sampler.Run(ctx)
+	return sampler
+}
+
+func get(handler http.HandlerFunc, url string, resp interface{}) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, url, nil))
+	json.Unmarshal(w.Body.Bytes(), resp)
+	return w
+}
+
+func TestTemperature(t *testing.T) {
+	handler := TemperatureHandlerFactory(newTestSampler(&fakeThermometer{temp: util.Temperature{Degrees: 21.5, Units: util.Celsius}}))
+
+	reading := new(thermometer.Reading)
+	if w := get(handler, "/temperature", reading); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	if reading.Version != thermometer.WireVersion || reading.SensorID != "hallway" || reading.Temperature != 21.5 || reading.Error != nil {
+		t.Errorf("Unexpected reading %+v", reading)
+	}
+
+	legacy := new(thermometer.TemperatureReading)
+	if w := get(handler, "/temperature?version=1", legacy); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	if legacy.Temperature != 21.5 || legacy.Units != util.Celsius || legacy.Error != "<nil>" {
+		t.Errorf("Unexpected legacy reading %+v", legacy)
+	}
+
+	if w := get(handler, "/temperature?version=9", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown version, got %d", w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/temperature", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", w.Code)
+	}
+}
+
+func TestTemperatureError(t *testing.T) {
+	sampler := newTestSampler(&fakeThermometer{err: errors.New("CRC mismatch")})
+	handler := TemperatureHandlerFactory(sampler)
+
+	// errors are reported in the reading, not the status code
+	reading := new(thermometer.Reading)
+	if w := get(handler, "/temperature", reading); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	if reading.Error == nil || reading.Error.Code != thermometer.ErrorCodeSensor || reading.Error.Message != "CRC mismatch" {
+		t.Errorf("Expected a sensor error, got %+v", reading.Error)
+	}
+
+	legacy := new(thermometer.TemperatureReading)
+	get(handler, "/temperature?version=1", legacy)
+	if legacy.Error != "CRC mismatch" {
+		t.Errorf("Expected the error in the legacy reading, got %+v", legacy)
+	}
+
+	health := new(thermometer.Health)
+	if w := get(HealthHandlerFactory(sampler), "/health", health); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without a reading, got %d", w.Code)
+	}
+	if health.Healthy || health.LastError != "CRC mismatch" || health.ConsecutiveErrors != 1 {
+		t.Errorf("Unexpected health %+v", health)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	handler := HealthHandlerFactory(newTestSampler(&fakeThermometer{temp: util.Temperature{Degrees: 20, Units: util.Celsius}}))
+
+	health := new(thermometer.Health)
+	if w := get(handler, "/health", health); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	if !health.Healthy || health.Samples != 1 || health.Reads != 1 {
+		t.Errorf("Unexpected health %+v", health)
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alittlebrighter/thermostat"
+	"github.com/alittlebrighter/thermostat/auth"
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+// ModesHandlerFactory serves GET /modes/ and GET/PUT/DELETE /modes/{name}.
+func ModesHandlerFactory(thermostatMain *thermostat.Thermostat) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/modes/")
+		if name == "" {
+			if r.Method != http.MethodGet {
+				util.MethodNotAllowed(w, r, "GET")
+				return
+			}
+			snapshot := thermostatMain.Copy()
+			setETag(w, snapshot.Revision)
+			util.WriteJSON(w, http.StatusOK, snapshot.Modes)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			snapshot := thermostatMain.Copy()
+			window, ok := snapshot.Modes[name]
+			if !ok {
+				writeThermostatError(w, thermostat.ErrNotFound)
+				return
+			}
+			setETag(w, snapshot.Revision)
+			util.WriteJSON(w, http.StatusOK, window)
+		case http.MethodPut:
+			change, ok := changeFrom(w, r)
+			if !ok {
+				return
+			}
+
+			window := new(thermostat.Window)
+			if err := json.NewDecoder(r.Body).Decode(window); err != nil {
+				util.WriteError(w, http.StatusBadRequest, "could not parse mode: "+err.Error())
+				return
+			}
+
+			created, err := thermostatMain.SetMode(change, name, window)
+			if err != nil {
+				writeThermostatError(w, err)
+				return
+			}
+
+			setETag(w, change.Revision)
+			if created {
+				w.Header().Set("Location", r.URL.Path)
+				util.WriteJSON(w, http.StatusCreated, window)
+				return
+			}
+			util.WriteJSON(w, http.StatusOK, window)
+		case http.MethodDelete:
+			change, ok := changeFrom(w, r)
+			if !ok {
+				return
+			}
+
+			if err := thermostatMain.DeleteMode(change, name); err != nil {
+				writeThermostatError(w, err)
+				return
+			}
+
+			setETag(w, change.Revision)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			util.MethodNotAllowed(w, r, "GET, PUT, DELETE")
+		}
+	}
+}
+
+// ScheduleHandlerFactory serves GET/POST /schedule and GET/PATCH /schedule/{id}.
+func ScheduleHandlerFactory(thermostatMain *thermostat.Thermostat) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/schedule"), "/")
+		if id == "" {
+			switch r.Method {
+			case http.MethodGet:
+				snapshot := thermostatMain.Copy()
+				setETag(w, snapshot.Revision)
+				util.WriteJSON(w, http.StatusOK, snapshot.Schedule)
+			case http.MethodPost:
+				change, ok := changeFrom(w, r)
+				if !ok {
+					return
+				}
+
+				spec := new(thermostat.ScheduleEvent)
+				if err := json.NewDecoder(r.Body).Decode(spec); err != nil {
+					util.WriteError(w, http.StatusBadRequest, "could not parse schedule entry: "+err.Error())
+					return
+				}
+				if err := thermostatMain.AddScheduleEvent(change, spec); err != nil {
+					writeThermostatError(w, err)
+					return
+				}
+
+				setETag(w, change.Revision)
+				w.Header().Set("Location", "/schedule/"+spec.ID)
+				util.WriteJSON(w, http.StatusCreated, spec)
+			default:
+				util.MethodNotAllowed(w, r, "GET, POST")
+			}
+			return
+		}
+
+		var spec *thermostat.ScheduleEvent
+		snapshot := thermostatMain.Copy()
+		for _, s := range snapshot.Schedule {
+			if s.ID == id {
+				// the body is decoded into spec, so it must not share Days with the live schedule
+				copied := *s
+				copied.Days = append([]time.Weekday(nil), s.Days...)
+				spec = &copied
+			}
+		}
+		if spec == nil {
+			writeThermostatError(w, thermostat.ErrNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			setETag(w, snapshot.Revision)
+		case http.MethodPatch:
+			change, ok := changeFrom(w, r)
+			if !ok {
+				return
+			}
+
+			// fields missing from the body keep their current values
+			if err := json.NewDecoder(r.Body).Decode(spec); err != nil {
+				util.WriteError(w, http.StatusBadRequest, "could not parse schedule entry: "+err.Error())
+				return
+			}
+			spec.ID = id
+
+			if err := thermostatMain.ReplaceScheduleEvent(change, spec); err != nil {
+				writeThermostatError(w, err)
+				return
+			}
+			setETag(w, change.Revision)
+		default:
+			util.MethodNotAllowed(w, r, "GET, PATCH")
+			return
+		}
+
+		util.WriteJSON(w, http.StatusOK, spec)
+	}
+}
+
+// StatusHandlerFactory serves GET /status.
+func StatusHandlerFactory(thermostatMain *thermostat.Thermostat) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			util.MethodNotAllowed(w, r, "GET")
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, thermostatMain.Status())
+	}
+}
+
+// FaultHandlerFactory serves GET /fault, the current fault of the HVAC system, and DELETE /fault to clear it so that
+// a system cut off by the fault may run again.
+func FaultHandlerFactory(thermostatMain *thermostat.Thermostat) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			fault := thermostatMain.Fault()
+			if fault == nil {
+				writeThermostatError(w, thermostat.ErrNotFound)
+				return
+			}
+			util.WriteJSON(w, http.StatusOK, fault)
+		case http.MethodDelete:
+			if err := thermostatMain.ClearFault(); err != nil {
+				writeThermostatError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			util.MethodNotAllowed(w, r, "GET, DELETE")
+		}
+	}
+}
+
+// EventsHandlerFactory serves GET /events, the most recent readings from oldest to newest.
+func EventsHandlerFactory(thermostatMain *thermostat.Thermostat) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			util.MethodNotAllowed(w, r, "GET")
+			return
+		}
+
+		events := []*util.EventLog{}
+		for _, event := range thermostatMain.Events.GetAll() {
+			if event != nil {
+				events = append(events, event)
+			}
+		}
+		util.WriteJSON(w, http.StatusOK, events)
+	}
+}
+
+// AuditHandlerFactory serves GET /audit, the most recent configuration changes.
+func AuditHandlerFactory(thermostatMain *thermostat.Thermostat) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			util.MethodNotAllowed(w, r, "GET")
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, thermostatMain.AuditTrail())
+	}
+}
+
+// changeFrom builds the Change for a request.  Clients must send the ETag of the configuration they based their
+// change on in an If-Match header so that they can't silently overwrite someone else's change.
+func changeFrom(w http.ResponseWriter, r *http.Request) (*thermostat.Change, bool) {
+	change := &thermostat.Change{Author: author(r)}
+
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	switch ifMatch {
+	case "":
+		util.WriteError(w, http.StatusPreconditionRequired, "If-Match header with the current ETag is required")
+		return nil, false
+	case "*":
+		change.Revision = thermostat.AnyRevision
+	default:
+		revision, err := strconv.ParseUint(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`), 10, 64)
+		if err != nil {
+			util.WriteError(w, http.StatusBadRequest, "malformed If-Match header "+ifMatch)
+			return nil, false
+		}
+		change.Revision = revision
+	}
+
+	return change, true
+}
+
+func author(r *http.Request) string {
+	name := "anonymous"
+	if principal := auth.PrincipalFrom(r.Context()); principal != nil {
+		name = principal.Name
+	}
+	if name == "anonymous" {
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			name += "@" + host
+		}
+	}
+	return name
+}
+
+func setETag(w http.ResponseWriter, revision uint64) {
+	w.Header().Set("ETag", fmt.Sprintf(`"%d"`, revision))
+}
+
+// HistoryHandlerFactory serves GET /config/history, GET /config/history/{rev} and POST /config/rollback/{rev}.
+func HistoryHandlerFactory(thermostatMain *thermostat.Thermostat, historyDir string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/config"), "/")
+		switch {
+		case path == "history":
+			if r.Method != http.MethodGet {
+				util.MethodNotAllowed(w, r, "GET")
+				return
+			}
+
+			history, err := readHistory(historyDir)
+			if err != nil {
+				util.WriteError(w, http.StatusInternalServerError, "could not read configuration history: "+err.Error())
+				return
+			}
+			util.WriteJSON(w, http.StatusOK, history)
+		case strings.HasPrefix(path, "history/"):
+			if r.Method != http.MethodGet {
+				util.MethodNotAllowed(w, r, "GET")
+				return
+			}
+
+			entry, ok := historyEntryFrom(w, historyDir, strings.TrimPrefix(path, "history/"))
+			if !ok {
+				return
+			}
+			util.WriteJSON(w, http.StatusOK, entry)
+		case strings.HasPrefix(path, "rollback/"):
+			if r.Method != http.MethodPost {
+				util.MethodNotAllowed(w, r, "POST")
+				return
+			}
+
+			change, ok := changeFrom(w, r)
+			if !ok {
+				return
+			}
+			entry, ok := historyEntryFrom(w, historyDir, strings.TrimPrefix(path, "rollback/"))
+			if !ok {
+				return
+			}
+			entry.Thermostat.Revision = entry.Revision
+
+			if err := thermostatMain.RollBack(change, entry.Thermostat); err != nil {
+				writeThermostatError(w, err)
+				return
+			}
+
+			setETag(w, change.Revision)
+			util.WriteJSON(w, http.StatusOK, thermostatMain)
+		default:
+			util.WriteError(w, http.StatusNotFound, r.URL.Path+" not found")
+		}
+	}
+}
+
+func historyEntryFrom(w http.ResponseWriter, historyDir, rev string) (*historyEntry, bool) {
+	revision, err := strconv.ParseUint(rev, 10, 64)
+	if err != nil {
+		util.WriteError(w, http.StatusBadRequest, "malformed revision "+rev)
+		return nil, false
+	}
+
+	entry, err := readHistoryEntry(historyDir, revision)
+	if os.IsNotExist(err) {
+		util.WriteError(w, http.StatusNotFound, fmt.Sprintf("no snapshot of revision %d", revision))
+		return nil, false
+	} else if err != nil {
+		util.WriteError(w, http.StatusInternalServerError, "could not read configuration history: "+err.Error())
+		return nil, false
+	} else if entry.Thermostat == nil {
+		util.WriteError(w, http.StatusInternalServerError, fmt.Sprintf("snapshot of revision %d is empty", revision))
+		return nil, false
+	}
+	return entry, true
+}
+
+func writeThermostatError(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case thermostat.InvalidError:
+		util.WriteError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	switch err {
+	case thermostat.ErrNotFound:
+		util.WriteError(w, http.StatusNotFound, err.Error())
+	case thermostat.ErrInUse:
+		util.WriteError(w, http.StatusConflict, err.Error())
+	case thermostat.ErrRevisionMismatch:
+		util.WriteError(w, http.StatusPreconditionFailed, err.Error())
+	default:
+		util.WriteError(w, http.StatusInternalServerError, err.Error())
+	}
+}
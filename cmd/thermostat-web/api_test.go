@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alittlebrighter/thermostat"
+)
+
+const testConfig = `{
+	"defaultMode": "default",
+	"unitPreference": "Celsius",
+	"pollInterval": "1m",
+	"modes": {"default": {"low": 18, "high": 24}, "night": {"low": 16, "high": 22}},
+	"schedule": [{"id": "weekdays", "days": [1, 2, 3], "mode": "night", "start": "12:01AM", "end": "7:00AM"}]
+}`
+
+func newTestThermostat(t *testing.T) *thermostat.Thermostat {
+	stat := new(thermostat.Thermostat)
+	if err := json.Unmarshal([]byte(testConfig), stat); err != nil {
+		t.Fatal(err)
+	}
+	return stat
+}
+
+func TestSchedulePatch(t *testing.T) {
+	stat := newTestThermostat(t)
+	if err := stat.SetHold(&thermostat.Change{Author: "test", Revision: 0}, nil); err != nil {
+		t.Fatal(err)
+	}
+	handler := ScheduleHandlerFactory(stat)
+
+	patch := func(ifMatch string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPatch, "/schedule/weekdays", strings.NewReader(`{"days": [0]}`))
+		r.Header.Set("If-Match", ifMatch)
+		handler(w, r)
+		return w
+	}
+
+	// a stale revision must not touch the live schedule
+	if w := patch(`"0"`); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("Expected 412 for a stale If-Match, got %d %s", w.Code, w.Body.String())
+	}
+	if days := stat.Copy().Schedule[0].Days; !reflect.DeepEqual(days, []time.Weekday{time.Monday, time.Tuesday, time.Wednesday}) {
+		t.Errorf("Rejected change modified the schedule: %v", days)
+	}
+
+	if w := patch(`"1"`); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	if days := stat.Copy().Schedule[0].Days; !reflect.DeepEqual(days, []time.Weekday{time.Sunday}) {
+		t.Errorf("Expected the days replaced, got %v", days)
+	}
+}
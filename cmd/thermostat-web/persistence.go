@@ -1,10 +1,20 @@
 package main
 
 import (
+	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ghodss/yaml"
+
+	"github.com/alittlebrighter/thermostat"
+	"github.com/alittlebrighter/thermostat/util"
 )
 
 func readState(path string) (*Config, error) {
@@ -14,20 +24,178 @@ func readState(path string) (*Config, error) {
 	}
 
 	config := new(Config)
-	err = yaml.Unmarshal(dat, config)
-	return config, err
+	if err = yaml.Unmarshal(dat, config); err != nil {
+		return config, err
+	}
+
+	// schedule entries without an ID are given a random one as they are decoded
+	ids := new(struct {
+		Thermostat struct {
+			Schedule []struct {
+				ID string `json:"id"`
+			} `json:"schedule"`
+		} `json:"thermostat"`
+	})
+	if err = yaml.Unmarshal(dat, ids); err != nil {
+		return config, err
+	}
+	for _, spec := range ids.Thermostat.Schedule {
+		if spec.ID == "" {
+			config.assignedIDs = true
+		}
+	}
+	return config, nil
 }
 
-func saveState(path string, config *Config) error {
-	events := *config.Thermostat.Events
-	config.Thermostat.Events = nil
+// saveState atomically replaces the configuration file with config, using snapshot for the thermostat.
+func saveState(path string, config *Config, snapshot *thermostat.Thermostat) error {
+	saved := *config
+	saved.Thermostat = snapshot
 
-	dat, err := yaml.Marshal(config)
+	dat, err := yaml.Marshal(&saved)
 	if err != nil {
 		return err
 	}
 
-	config.Thermostat.Events = &events
+	return util.WriteFileAtomic(path, dat, os.FileMode(int(0660)))
+}
+
+// persister saves accepted configuration changes in the background, in order, so that writing and syncing files holds
+// up neither the thermostat nor the request that changed it.
+type persister struct {
+	configFile string
+	config     *Config
+	historyDir string
+	changes    chan *historyEntry
+	done       chan struct{}
+}
+
+// changeQueue is how many changes can wait to be saved before Save blocks.
+const changeQueue = 16
+
+func newPersister(configFile string, config *Config, historyDir string) *persister {
+	p := &persister{configFile: configFile, config: config, historyDir: historyDir,
+		changes: make(chan *historyEntry, changeQueue), done: make(chan struct{})}
+	go p.run()
+	return p
+}
+
+// Save queues a change to be saved, it is the thermostat's change listener.
+func (p *persister) Save(change *thermostat.AuditEntry, snapshot *thermostat.Thermostat) {
+	p.changes <- &historyEntry{Revision: change.Revision, Time: change.Time, Author: change.Author, Action: change.Action, Thermostat: snapshot}
+}
+
+// Close saves the changes that are still queued.  Save must not be called afterwards.
+func (p *persister) Close() {
+	close(p.changes)
+	<-p.done
+}
+
+func (p *persister) run() {
+	defer close(p.done)
+	for entry := range p.changes {
+		if err := saveState(p.configFile, p.config, entry.Thermostat); err != nil {
+			log.Println("Error saving state: " + err.Error())
+		}
+		if err := saveHistory(p.historyDir, entry); err != nil {
+			log.Println("Error saving configuration history: " + err.Error())
+		}
+	}
+}
+
+// maxHistory is how many configuration snapshots are kept.
+const maxHistory = 200
+
+// historyEntry is the thermostat configuration as it was accepted at Revision.
+type historyEntry struct {
+	Revision   uint64                 `json:"revision"`
+	Time       time.Time              `json:"time"`
+	Author     string                 `json:"author"`
+	Action     string                 `json:"action"`
+	Thermostat *thermostat.Thermostat `json:"thermostat,omitempty"`
+}
+
+func historyFile(dir string, revision uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d.json", revision))
+}
+
+// saveHistory stores entry in dir and prunes the oldest snapshots beyond maxHistory.
+func saveHistory(dir string, entry *historyEntry) error {
+	if err := os.MkdirAll(dir, os.FileMode(0770)); err != nil {
+		return err
+	}
+	if err := util.WriteJSONFile(historyFile(dir, entry.Revision), entry); err != nil {
+		return err
+	}
+
+	revisions, err := historyRevisions(dir)
+	if err != nil {
+		return err
+	}
+	for len(revisions) > maxHistory {
+		if err := os.Remove(historyFile(dir, revisions[0])); err != nil {
+			return err
+		}
+		revisions = revisions[1:]
+	}
+	return nil
+}
+
+// historyRevisions lists the revisions with a snapshot in dir, oldest first.
+func historyRevisions(dir string) ([]uint64, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := []uint64{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		revision, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+		revisions = append(revisions, revision)
+	}
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i] < revisions[j] })
+	return revisions, nil
+}
+
+func readHistoryEntry(dir string, revision uint64) (*historyEntry, error) {
+	entry := new(historyEntry)
+	err := util.ReadJSONFile(historyFile(dir, revision), entry)
+	return entry, err
+}
+
+// readHistory returns every snapshot in dir without the configuration itself, oldest first.
+func readHistory(dir string) ([]*historyEntry, error) {
+	revisions, err := historyRevisions(dir)
+	if os.IsNotExist(err) {
+		return []*historyEntry{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*historyEntry, 0, len(revisions))
+	for _, revision := range revisions {
+		entry, err := readHistoryEntry(dir, revision)
+		if err != nil {
+			return nil, err
+		}
+		entry.Thermostat = nil
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func readRunState(path string) (*thermostat.RunState, error) {
+	state := new(thermostat.RunState)
+	err := util.ReadJSONFile(path, state)
+	return state, err
+}
 
-	return ioutil.WriteFile(path, dat, os.FileMode(int(0660)))
+func saveRunState(path string, state *thermostat.RunState) error {
+	return util.WriteJSONFile(path, state)
 }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alittlebrighter/thermostat"
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+func TestPersister(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thermostat-web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configFile, historyDir := filepath.Join(dir, "thermostat.conf"), filepath.Join(dir, "history")
+	persisted := newPersister(configFile, &Config{ServeAt: ":8080"}, historyDir)
+	for revision := uint64(1); revision <= 3; revision++ {
+		snapshot := &thermostat.Thermostat{DefaultMode: "default", Revision: revision}
+		persisted.Save(&thermostat.AuditEntry{Revision: revision, Time: time.Now(), Author: "test", Action: "set mode default"}, snapshot)
+	}
+	persisted.Close()
+
+	revisions, err := historyRevisions(historyDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 3 || revisions[2] != 3 {
+		t.Errorf("Expected every change in the history, got %v", revisions)
+	}
+
+	config, err := readState(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Thermostat == nil || config.Thermostat.Revision != 3 || config.ServeAt != ":8080" {
+		t.Errorf("Expected the last change saved to the configuration file, got %+v", config)
+	}
+}
+
+func TestReadState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thermostat-web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "thermostat.conf")
+	data := `
+thermostat:
+  defaultMode: default
+  unitPreference: Fahrenheit
+  units: Celsius
+  modes:
+    default: {low: 20, high: 24}
+    night: {low: 60, high: 77, units: Fahrenheit}
+    away: {low: "10°C", high: 86F}
+  safety: {low: 7, high: 32}
+`
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := readState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Thermostat.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	stat := config.Thermostat.Copy()
+	if window := stat.Modes["default"]; window.Low.Degrees != 68 || window.Units() != util.Fahrenheit {
+		t.Errorf("Expected the default mode converted to Fahrenheit, got %+v", window)
+	}
+	if window := stat.Modes["night"]; window.Low.Degrees != 60 || window.High.Degrees != 77 {
+		t.Errorf("Expected the night mode left in Fahrenheit, got %+v", window)
+	}
+	if window := stat.Modes["away"]; window.Low.Degrees != 50 || window.High.Degrees != 86 || window.High.Units != util.Fahrenheit {
+		t.Errorf("Expected the away mode converted to Fahrenheit, got %+v", window)
+	}
+	if stat.Safety.High.Degrees < 89.5 || stat.Safety.High.Degrees > 89.7 {
+		t.Errorf("Expected the safety limits converted to Fahrenheit, got %+v", stat.Safety)
+	}
+
+	// the example configuration
+	if config, err = readState("../../config.yml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Thermostat.Normalize(); err != nil {
+		t.Errorf("The example configuration has invalid units: %s", err.Error())
+	}
+}
+
+func TestScheduleIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thermostat-web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "thermostat.conf")
+	data, err := ioutil.ReadFile("../../config.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := readState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.assignedIDs {
+		t.Fatal("Expected IDs assigned to the schedule of the example configuration")
+	}
+	if err := saveState(path, config, config.Thermostat); err != nil {
+		t.Fatal(err)
+	}
+
+	reread, err := readState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reread.assignedIDs {
+		t.Error("Expected the assigned IDs saved")
+	}
+	for i, spec := range config.Thermostat.Schedule {
+		if reread.Thermostat.Schedule[i].ID != spec.ID {
+			t.Errorf("Schedule entry %d changed ID from %s to %s", i, spec.ID, reread.Thermostat.Schedule[i].ID)
+		}
+	}
+}
@@ -1,27 +1,46 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/alittlebrighter/thermostat"
+	"github.com/alittlebrighter/thermostat/auth"
 	"github.com/alittlebrighter/thermostat/controller"
+	"github.com/alittlebrighter/thermostat/mqtt"
+	"github.com/alittlebrighter/thermostat/notify"
 	"github.com/alittlebrighter/thermostat/thermometer"
 	"github.com/alittlebrighter/thermostat/util"
 )
 
-const DEFAULT_CONFIG = "/etc/thermostat.conf"
+const (
+	DEFAULT_CONFIG   = "/etc/thermostat.conf"
+	DEFAULT_STATE    = "/var/lib/thermostat/state.json"
+	DEFAULT_HISTORY  = "/var/lib/thermostat/history"
+	STREAM_BACKLOG   = 200
+	SHUTDOWN_TIMEOUT = 10 * time.Second
+)
 
 func main() {
 	log.Println("Starting thermostat.")
 
+	ctx, stop := util.SignalContext(context.Background())
+	defer stop()
+
 	config, err := readState(DEFAULT_CONFIG)
 	if err != nil {
 		log.Fatalln(err.Error())
 	}
+	if config.assignedIDs {
+		// save the IDs given to schedule entries so that they stay the same across restarts
+		if err := saveState(DEFAULT_CONFIG, config, config.Thermostat); err != nil {
+			log.Println("Error saving schedule IDs: " + err.Error())
+		}
+	}
 
 	log.Println("Setting up controller.")
 	control, err := controller.NewCentralController(config.Controller.Pins.Heat, config.Controller.Pins.Cool, config.Controller.Pins.Fan, 1*time.Minute)
@@ -33,85 +52,262 @@ func main() {
 	defer control.Off()
 
 	log.Println("Getting thermometer.")
-	thermometer, err := thermometer.NewRemote(config.Thermometer.Endpoint)
+	meter, err := config.Thermometer.NewThermometer()
 	if err != nil {
-		log.Fatalln("Error getting thermometer instance: " + err.Error())
+		log.Println("Error getting thermometer instance: " + err.Error())
+		return
+	}
+	defer meter.Shutdown()
+
+	var secondary thermometer.Thermometer
+	if config.Thermometer.Secondary != nil {
+		log.Println("Getting secondary thermometer.")
+		if secondary, err = config.Thermometer.Secondary.NewThermometer(); err != nil {
+			log.Println("Error getting secondary thermometer instance: " + err.Error())
+			return
+		}
+		defer secondary.Shutdown()
 	}
-	defer thermometer.Shutdown()
 
 	log.Println("Initializing thermostat.")
 	thermostatMain := config.Thermostat
+	if err := thermostatMain.Normalize(); err != nil {
+		log.Println("Invalid configuration: " + err.Error())
+		return
+	}
 	if _, ok := thermostatMain.Modes[thermostatMain.DefaultMode]; !ok {
-		log.Fatalln("Invalid default mode.")
+		log.Println("Invalid default mode.")
+		return
 	}
 
 	thermostatMain.Events = util.NewRingBuffer(60)
-	thermostatMain.LastFan = time.Now()
+	stream := util.NewStream(STREAM_BACKLOG)
+	thermostatMain.SetStream(stream)
 	thermostatMain.SetController(control)
-	thermostatMain.SetThermometer(thermometer)
+	thermostatMain.SetThermometer(meter)
+	if secondary != nil {
+		thermostatMain.SetSecondaryThermometer(secondary)
+	}
 
-	cancel := make(chan bool)
-	defer close(cancel)
-	go thermostatMain.Run(cancel)
+	stateFile := config.StateFile
+	if stateFile == "" {
+		stateFile = DEFAULT_STATE
+	}
+	if state, err := readRunState(stateFile); err == nil {
+		thermostatMain.Recover(state)
+	} else {
+		if !os.IsNotExist(err) {
+			log.Println("Error reading run state: " + err.Error())
+		}
+		thermostatMain.LastFan = time.Now()
+	}
+	thermostatMain.SetRunStateListener(func(state *thermostat.RunState) {
+		if err := saveRunState(stateFile, state); err != nil {
+			log.Println("Error saving run state: " + err.Error())
+		}
+	})
+	if err := saveRunState(stateFile, thermostatMain.CurrentRunState()); err != nil {
+		log.Println("Error saving run state: " + err.Error())
+	}
 
-	http.HandleFunc("/", CORSFilterFactory(ConfigHandlerFactory(thermostatMain, config, cancel)))
+	historyDir := config.HistoryDir
+	if historyDir == "" {
+		historyDir = DEFAULT_HISTORY
+	}
+	initial := thermostatMain.Copy()
+	if _, err := readHistoryEntry(historyDir, initial.Revision); os.IsNotExist(err) {
+		entry := &historyEntry{Revision: initial.Revision, Time: time.Now(), Author: "config file", Action: "loaded " + DEFAULT_CONFIG, Thermostat: initial}
+		if err := saveHistory(historyDir, entry); err != nil {
+			log.Println("Error saving configuration history: " + err.Error())
+		}
+	}
+	persisted := newPersister(DEFAULT_CONFIG, config, historyDir)
+	thermostatMain.SetChangeListener(persisted.Save)
+
+	bridged := make(chan struct{})
+	if config.MQTT.Enabled() {
+		log.Println("Connecting to MQTT broker at " + config.MQTT.Broker)
+		if client, err := mqtt.Connect(&config.MQTT); err == nil {
+			defer client.Disconnect()
+			go func() {
+				defer close(bridged)
+				mqtt.NewBridge(client, thermostatMain, &config.MQTT).Run(ctx, stream)
+			}()
+		} else {
+			// MQTT is optional, keep heating and cooling without it
+			log.Println("Error connecting to MQTT broker, running without it: " + err.Error())
+			close(bridged)
+		}
+	} else {
+		close(bridged)
+	}
+
+	notified := make(chan struct{})
+	if config.Notify.Enabled() {
+		if err := config.Notify.Validate(); err != nil {
+			log.Println("Invalid notification rules: " + err.Error())
+			return
+		}
+
+		go func() {
+			defer close(notified)
+			notify.NewNotifier(thermostatMain, &config.Notify).Run(ctx, stream)
+		}()
+	} else {
+		close(notified)
+	}
 
-	log.Println("Starting web server.")
-	log.Fatal(http.ListenAndServe(config.ServeAt, nil))
+	running := make(chan struct{})
+	go func() {
+		defer close(running)
+		thermostatMain.Run(ctx)
+	}()
+
+	if !config.Auth.Enabled() {
+		log.Println("WARNING: no API tokens or users configured, every caller is an admin and anyone on the network can change the thermostat.")
+	} else if config.Auth.AnonymousRole == auth.Admin {
+		log.Println("WARNING: anonymousRole is admin, anyone on the network can change the thermostat without credentials.")
+	}
+	mux := newServeMux(ctx, config, thermostatMain, stream, historyDir)
+	server := &http.Server{Addr: config.ServeAt, Handler: mux}
+	if server.TLSConfig, err = config.TLS.ServerConfig(); err != nil {
+		log.Println("Error loading TLS certificates: " + err.Error())
+		return
+	}
+
+	go func() {
+		log.Println("Starting web server.")
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			log.Println("Error serving HTTP: " + err.Error())
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("Error stopping web server: " + err.Error())
+	}
+	<-running
+	<-bridged
+	<-notified
+	persisted.Close()
+
+	if err := saveState(DEFAULT_CONFIG, config, thermostatMain.Copy()); err != nil {
+		log.Println("Error saving state: " + err.Error())
+	}
+	state := thermostatMain.CurrentRunState()
+	state.CleanShutdown = true
+	if err := saveRunState(stateFile, state); err != nil {
+		log.Println("Error saving run state: " + err.Error())
+	}
+	log.Println("Thermostat stopped.")
+}
+
+// newServeMux routes the API, requiring the roles configured in config.Auth.
+func newServeMux(ctx context.Context, config *Config, thermostatMain *thermostat.Thermostat, stream *util.Stream, historyDir string) *http.ServeMux {
+	authn := auth.NewAuthenticator(&config.Auth)
+
+	handleAs := func(write auth.Role, handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+		return CORSFilterFactory(config.Auth.CORSOrigins, auth.Require(authn, auth.ReadWrite(auth.Viewer, write), handler))
+	}
+	handle := func(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+		return handleAs(auth.Editor, handler)
+	}
+
+	mux := http.NewServeMux()
+	// replacing the whole configuration or clearing a fault can lift the safety limits, failure policy and cut-offs
+	mux.HandleFunc("/", handleAs(auth.Admin, ConfigHandlerFactory(thermostatMain)))
+	mux.HandleFunc("/modes/", handle(ModesHandlerFactory(thermostatMain)))
+	mux.HandleFunc("/schedule", handle(ScheduleHandlerFactory(thermostatMain)))
+	mux.HandleFunc("/schedule/", handle(ScheduleHandlerFactory(thermostatMain)))
+	mux.HandleFunc("/status", handle(StatusHandlerFactory(thermostatMain)))
+	mux.HandleFunc("/fault", handleAs(auth.Admin, FaultHandlerFactory(thermostatMain)))
+	mux.HandleFunc("/events", handle(EventsHandlerFactory(thermostatMain)))
+	mux.HandleFunc("/audit", handle(AuditHandlerFactory(thermostatMain)))
+	// browsers can't set headers on an EventSource
+	mux.HandleFunc("/stream", auth.QueryToken(handle(StreamHandlerFactory(ctx, stream))))
+	mux.HandleFunc("/config/", handleAs(auth.Admin, HistoryHandlerFactory(thermostatMain, historyDir)))
+	return mux
 }
 
 // Config defines the configuration needed to run the thermostat.
 type Config struct {
 	thermostat.Config
-	ServeAt string `json:"serveAt"`
+	ServeAt    string         `json:"serveAt"`
+	StateFile  string         `json:"stateFile"`
+	HistoryDir string         `json:"historyDir"`
+	Auth       auth.Config    `json:"auth"`
+	TLS        util.TLSConfig `json:"tls"`
+	MQTT       mqtt.Config    `json:"mqtt"`
+	Notify     notify.Config  `json:"notify"`
+
+	// assignedIDs is set when schedule entries in the file had no ID and were given one while reading it.
+	assignedIDs bool
 }
 
-func ConfigHandlerFactory(thermostatMain *thermostat.Thermostat, config *Config, cancel chan bool) func(http.ResponseWriter, *http.Request) {
+// ConfigHandlerFactory serves GET / with the whole thermostat and POST / to replace its configuration at once.
+func ConfigHandlerFactory(thermostatMain *thermostat.Thermostat) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "POST" {
+		if r.URL.Path != "/" {
+			util.WriteError(w, http.StatusNotFound, r.URL.Path+" not found")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			change, ok := changeFrom(w, r)
+			if !ok {
+				return
+			}
+
 			newThermostat := new(thermostat.Thermostat)
 			err := json.NewDecoder(r.Body).Decode(newThermostat)
 			if err != nil {
-				w.WriteHeader(500)
-				fmt.Fprintf(w, "ERROR: "+err.Error())
+				util.WriteError(w, http.StatusBadRequest, "could not parse thermostat: "+err.Error())
 				return
 			}
 
 			valid := newThermostat.Validate()
 			if valid != "" {
-				w.WriteHeader(422)
-				fmt.Fprintf(w, "ERROR: invalid thermostat configuration. "+valid)
+				util.WriteError(w, http.StatusUnprocessableEntity, "invalid thermostat configuration. "+valid)
 				return
 			}
 
-			thermostatMain.DefaultMode = newThermostat.DefaultMode
-			thermostatMain.MaxErrors = newThermostat.MaxErrors
-			thermostatMain.Modes = newThermostat.Modes
-			thermostatMain.Overshoot = newThermostat.Overshoot
-			thermostatMain.PollInterval = newThermostat.PollInterval
-			thermostatMain.MinFan = newThermostat.MinFan
-			thermostatMain.Schedule = newThermostat.Schedule
-			thermostatMain.UnitPreference = newThermostat.UnitPreference
-
-			cancel <- true
-			go thermostatMain.Run(cancel)
-			go saveState(DEFAULT_CONFIG, config)
-		}
-
-		err := json.NewEncoder(w).Encode(thermostatMain)
-		if err != nil {
-			w.WriteHeader(500)
-			fmt.Fprintf(w, "ERROR: could not marshal thermostat struct.")
+			if err := thermostatMain.Replace(change, newThermostat); err != nil {
+				writeThermostatError(w, err)
+				return
+			}
+		default:
+			util.MethodNotAllowed(w, r, "GET, POST")
 			return
 		}
+
+		// read the revision first so that a concurrent change can only make the ETag stale, never newer than the body
+		setETag(w, thermostatMain.Copy().Revision)
+		util.WriteJSON(w, http.StatusOK, thermostatMain)
 	}
 }
 
-func CORSFilterFactory(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+// CORSFilterFactory allows browsers to call handler from any of the origins listed.
+func CORSFilterFactory(origins []string, handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Add("Access-Control-Allow-Methods", "GET,POST")
-		w.Header().Add("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Add("Vary", "Origin")
+		if origin := auth.AllowedOrigin(origins, r.Header.Get("Origin")); origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE")
+			w.Header().Add("Access-Control-Allow-Headers", "Content-Type,Authorization,If-Match")
+			w.Header().Add("Access-Control-Expose-Headers", "ETag,Location")
+		}
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(200)
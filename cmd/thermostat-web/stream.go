@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+// HEARTBEAT keeps idle streams from being closed by proxies and lets us notice clients that went away.
+const HEARTBEAT = 30 * time.Second
+
+// StreamHandlerFactory serves GET /stream, a Server-Sent Events stream of every reading, error, direction change and
+// configuration change as it happens.  Clients resume where they left off by sending the Last-Event-ID header (browsers
+// do this automatically when reconnecting) or the lastEventId query parameter.  Streams are closed once ctx is done.
+func StreamHandlerFactory(ctx context.Context, stream *util.Stream) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			util.MethodNotAllowed(w, r, "GET")
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			util.WriteError(w, http.StatusInternalServerError, "streaming is not supported")
+			return
+		}
+
+		var lastID uint64
+		if last := r.Header.Get("Last-Event-ID"); last != "" {
+			lastID, _ = strconv.ParseUint(last, 10, 64)
+		} else if last := r.URL.Query().Get("lastEventId"); last != "" {
+			lastID, _ = strconv.ParseUint(last, 10, 64)
+		}
+
+		missed, events, unsubscribe := stream.Subscribe(lastID)
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "retry: 3000\n\n")
+
+		for _, event := range missed {
+			if err := writeStreamEvent(w, event); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(HEARTBEAT)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					// dropped for falling behind, the client will reconnect and catch up
+					return
+				}
+				if err := writeStreamEvent(w, event); err != nil {
+					return
+				}
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			case <-ctx.Done():
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeStreamEvent(w http.ResponseWriter, event *util.StreamEvent) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		log.Println("Error marshalling stream event: " + err.Error())
+		return nil
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
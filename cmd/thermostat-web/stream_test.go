@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alittlebrighter/thermostat/auth"
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+func TestStreamWithAuth(t *testing.T) {
+	sum := sha256.Sum256([]byte("dashboard-token"))
+	config := &Config{Auth: auth.Config{Tokens: []auth.Token{{Name: "dashboard", Hash: hex.EncodeToString(sum[:]), Role: auth.Viewer}}}}
+
+	// streams end right away once ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	mux := newServeMux(ctx, config, newTestThermostat(t), util.NewStream(10), "")
+
+	for _, test := range []struct {
+		name   string
+		url    string
+		header string
+		code   int
+	}{
+		{"no credentials", "/stream", "", http.StatusUnauthorized},
+		{"header", "/stream", "Bearer dashboard-token", http.StatusOK},
+		{"query", "/stream?access_token=dashboard-token", "", http.StatusOK},
+		{"wrong query", "/stream?access_token=guess", "", http.StatusUnauthorized},
+		{"query elsewhere", "/status?access_token=dashboard-token", "", http.StatusUnauthorized},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, test.url, nil)
+		if test.header != "" {
+			r.Header.Set("Authorization", test.header)
+		}
+		mux.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("%s: expected %d, got %d %s", test.name, test.code, w.Code, w.Body.String())
+		}
+		if test.code == http.StatusOK && !strings.HasPrefix(w.Body.String(), "retry:") {
+			t.Errorf("%s: expected an event stream, got %q", test.name, w.Body.String())
+		}
+	}
+}
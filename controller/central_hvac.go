@@ -2,6 +2,7 @@ package controller
 
 import (
 	"log"
+	"sync"
 	"time"
 
 	"github.com/stianeikeland/go-rpio"
@@ -12,14 +13,26 @@ const (
 	off = rpio.High
 )
 
-// CentralController holds all of the data necessary to run a central HVAC system.
+// Pin is a GPIO output that drives one of the relays of a central HVAC system.  rpio.Pin satisfies this interface.
+type Pin interface {
+	Output()
+	Write(rpio.State)
+	Read() rpio.State
+}
+
+// CentralController holds all of the data necessary to run a central HVAC system.  It is safe for concurrent use.
 type CentralController struct {
-	fan, heat, cool rpio.Pin
+	mu sync.Mutex
+
+	fan, heat, cool Pin
+	closePins       func() error
 
-	fanCoolingDown  bool
 	fanCooldownTime time.Duration
-	fanCancel       chan bool
-	direction       ThermoDirection
+	fanTimer        *time.Timer
+	// fanGeneration is bumped every time a fan cooldown is started or cancelled so that a timer that fires after it
+	// was cancelled can tell it is stale.
+	fanGeneration uint64
+	direction     ThermoDirection
 }
 
 // NewCentralController initializes the controller for a central HVAC system.
@@ -29,15 +42,15 @@ func NewCentralController(heatPin, coolPin, fanPin int, fanCooldown time.Duratio
 		return nil, err
 	}
 
-	c := new(CentralController)
-	c.direction = None
-
 	log.Printf("Using pin %d to control HEAT.", heatPin)
-	c.heat = rpio.Pin(heatPin)
 	log.Printf("Using pin %d to control AC.", coolPin)
-	c.cool = rpio.Pin(coolPin)
 	log.Printf("Using pin %d to control FAN.", fanPin)
-	c.fan = rpio.Pin(fanPin)
+	return newCentralController(rpio.Pin(heatPin), rpio.Pin(coolPin), rpio.Pin(fanPin), fanCooldown, rpio.Close), nil
+}
+
+func newCentralController(heat, cool, fan Pin, fanCooldown time.Duration, closePins func() error) *CentralController {
+	c := &CentralController{heat: heat, cool: cool, fan: fan, closePins: closePins, direction: None}
+
 	log.Printf("Setting FAN cooldown time to %v.", fanCooldown)
 	c.fanCooldownTime = fanCooldown
 
@@ -49,37 +62,74 @@ func NewCentralController(heatPin, coolPin, fanPin int, fanCooldown time.Duratio
 	c.heat.Write(off)
 	c.cool.Write(off)
 
-	c.fanCancel = make(chan bool)
-
-	return c, nil
+	return c
 }
 
 // Direction is a getter for the direction of the HVAC system.
 func (c *CentralController) Direction() ThermoDirection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	return c.direction
 }
 
-func (c *CentralController) fanCooldown() {
-	c.fanCoolingDown = true
+// FanCoolingDown reports whether the fan is still running to push out the air left in the ducts after heating or
+// cooling was shut off.
+func (c *CentralController) FanCoolingDown() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.fanTimer != nil
+}
 
-	timer := time.NewTimer(c.fanCooldownTime)
-	select {
-	case <-timer.C:
-	case <-c.fanCancel:
+// PinLevels reports which of the fan, heat and cool relays are currently energized.
+func (c *CentralController) PinLevels() PinLevels {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return PinLevels{
+		Fan:  c.fan.Read() == on,
+		Heat: c.heat.Read() == on,
+		Cool: c.cool.Read() == on,
 	}
-	timer.Stop()
-	c.fan.Write(off)
+}
+
+// startFanCooldown keeps the fan running for fanCooldownTime.  Must be called with mu held.
+func (c *CentralController) startFanCooldown() {
+	c.cancelFanCooldown()
 
-	c.fanCoolingDown = false
+	generation := c.fanGeneration
+	c.fanTimer = time.AfterFunc(c.fanCooldownTime, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if generation != c.fanGeneration {
+			return
+		}
+		c.fan.Write(off)
+		c.fanTimer = nil
+	})
+}
+
+// cancelFanCooldown stops a running fan cooldown without touching the fan.  Must be called with mu held.
+func (c *CentralController) cancelFanCooldown() {
+	c.fanGeneration++
+	if c.fanTimer != nil {
+		c.fanTimer.Stop()
+		c.fanTimer = nil
+	}
 }
 
 // Off shuts down all HVAC components.
 func (c *CentralController) Off() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	c.heat.Write(off)
 	c.cool.Write(off)
-	if c.Direction() == Heating || c.Direction() == Cooling {
-		go c.fanCooldown()
-	} else {
+	if c.direction == Heating || c.direction == Cooling {
+		c.startFanCooldown()
+	} else if c.fanTimer == nil {
 		c.fan.Write(off)
 	}
 
@@ -88,11 +138,11 @@ func (c *CentralController) Off() {
 
 // Fan turns on the central fan while shutting down heating and cooling elements.
 func (c *CentralController) Fan() {
-	c.direction = Fan
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	if c.fanCoolingDown {
-		c.fanCancel <- true
-	}
+	c.direction = Fan
+	c.cancelFanCooldown()
 
 	c.fan.Write(on)
 	c.heat.Write(off)
@@ -101,11 +151,11 @@ func (c *CentralController) Fan() {
 
 // Heat turns on the heating element and central fan.
 func (c *CentralController) Heat() {
-	c.direction = Heating
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	if c.fanCoolingDown {
-		c.fanCancel <- true
-	}
+	c.direction = Heating
+	c.cancelFanCooldown()
 
 	c.fan.Write(on)
 	c.cool.Write(off)
@@ -114,11 +164,11 @@ func (c *CentralController) Heat() {
 
 // Cool turns on the air conditioner and central fan.
 func (c *CentralController) Cool() {
-	c.direction = Cooling
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	if c.fanCoolingDown {
-		c.fanCancel <- true
-	}
+	c.direction = Cooling
+	c.cancelFanCooldown()
 
 	c.fan.Write(on)
 	c.cool.Write(on)
@@ -127,15 +177,17 @@ func (c *CentralController) Cool() {
 
 // Shutdown turns off all HVAC components and closes the GPIO connection.
 func (c *CentralController) Shutdown() {
-	c.direction = None
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	if c.fanCoolingDown {
-		c.fanCancel <- true
-	}
+	c.direction = None
+	c.cancelFanCooldown()
 
 	c.cool.Write(off)
 	c.heat.Write(off)
 	c.fan.Write(off)
 
-	rpio.Close()
+	if c.closePins != nil {
+		c.closePins()
+	}
 }
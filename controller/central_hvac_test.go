@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stianeikeland/go-rpio"
+)
+
+func TestCentralControllerDirections(t *testing.T) {
+	c, heat, cool, fan := newTestController(time.Hour)
+
+	c.Heat()
+	if c.Direction() != Heating || !heat.on() || cool.on() || !fan.on() {
+		t.Error("Failed to turn on HEAT.")
+	}
+
+	c.Cool()
+	if c.Direction() != Cooling || heat.on() || !cool.on() || !fan.on() {
+		t.Error("Failed to turn on COOL.")
+	}
+
+	c.Fan()
+	if c.Direction() != Fan || heat.on() || cool.on() || !fan.on() {
+		t.Error("Failed to turn on FAN.")
+	}
+
+	c.Off()
+	if c.Direction() != None || heat.on() || cool.on() || fan.on() {
+		t.Error("Failed to turn everything OFF.")
+	}
+}
+
+func TestCentralControllerFanCooldown(t *testing.T) {
+	c, heat, _, fan := newTestController(20 * time.Millisecond)
+
+	c.Heat()
+	c.Off()
+	if heat.on() || !fan.on() || !c.FanCoolingDown() {
+		t.Error("Fan did not keep running after HEAT was shut off.")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for c.FanCoolingDown() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if fan.on() || c.FanCoolingDown() {
+		t.Error("Fan did not shut off after cooldown.")
+	}
+}
+
+func TestCentralControllerCancelCooldown(t *testing.T) {
+	c, heat, _, fan := newTestController(20 * time.Millisecond)
+
+	c.Cool()
+	c.Off()
+	// used to block forever when the cooldown goroutine was no longer listening
+	c.Heat()
+	if c.FanCoolingDown() {
+		t.Error("Fan cooldown was not cancelled.")
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if !heat.on() || !fan.on() {
+		t.Error("Cancelled fan cooldown shut off the fan.")
+	}
+}
+
+func TestCentralControllerConcurrentUse(t *testing.T) {
+	c, heat, cool, fan := newTestController(time.Millisecond)
+
+	commands := []func(){c.Heat, c.Cool, c.Fan, c.Off}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				commands[(i+j)%len(commands)]()
+				c.Direction()
+				c.PinLevels()
+				c.FanCoolingDown()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	c.Shutdown()
+	if c.Direction() != None || heat.on() || cool.on() || fan.on() {
+		t.Error("Failed to shut everything down.")
+	}
+}
+
+func newTestController(fanCooldown time.Duration) (c *CentralController, heat, cool, fan *fakePin) {
+	heat, cool, fan = new(fakePin), new(fakePin), new(fakePin)
+	c = newCentralController(heat, cool, fan, fanCooldown, nil)
+	return
+}
+
+type fakePin struct {
+	mu    sync.Mutex
+	state rpio.State
+}
+
+func (p *fakePin) Output() {}
+
+func (p *fakePin) Write(state rpio.State) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state = state
+}
+
+func (p *fakePin) Read() rpio.State {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+func (p *fakePin) on() bool {
+	return p.Read() == on
+}
@@ -1,5 +1,11 @@
 package controller
 
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
 // Controller defines a struct that is capable of performing all of the necessary actions to change the temperature.
 type Controller interface {
 	Direction() ThermoDirection
@@ -37,6 +43,37 @@ func (d ThermoDirection) MarshalText() (text []byte, err error) {
 	return []byte(d.String()), nil
 }
 
+func (d *ThermoDirection) UnmarshalText(text []byte) error {
+	switch strings.ToLower(string(text)) {
+	case "heating":
+		*d = Heating
+	case "cooling":
+		*d = Cooling
+	case "fan":
+		*d = Fan
+	case "none", "off":
+		*d = None
+	default:
+		return fmt.Errorf("unknown direction %q", text)
+	}
+	return nil
+}
+
+// State records the last command given to a controller so that it can be recovered after a restart.
+type State struct {
+	Direction ThermoDirection `json:"direction"`
+	Since     time.Time       `json:"since"`
+	// CleanShutdown is only set when the process stopped on purpose, otherwise it was killed or lost power.
+	CleanShutdown bool `json:"cleanShutdown"`
+}
+
+// PinLevels holds whether each relay of a central HVAC system is energized.
+type PinLevels struct {
+	Fan  bool `json:"fan"`
+	Heat bool `json:"heat"`
+	Cool bool `json:"cool"`
+}
+
 type Config struct {
 	Pins struct{ Fan, Cool, Heat int }
 }
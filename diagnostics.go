@@ -0,0 +1,138 @@
+package thermostat
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/alittlebrighter/thermostat/controller"
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+// Diagnostics configures fault detection: heating or cooling is ineffective if it runs for After without moving the
+// temperature by at least MinChange degrees of UnitPreference in the right direction, e.g. because of a failed
+// igniter or a tripped breaker.
+type Diagnostics struct {
+	After     util.Duration `json:"after"`
+	MinChange float64       `json:"minChange"`
+	// CutOff turns the HVAC system off when a fault is detected and keeps it from heating or cooling (whichever was
+	// ineffective) until the fault is cleared.
+	CutOff bool `json:"cutOff"`
+}
+
+// Kinds of Fault.
+const (
+	FaultHeatingIneffective = "heating ineffective"
+	FaultCoolingIneffective = "cooling ineffective"
+)
+
+// Fault is a problem with the HVAC system found by Diagnostics.
+type Fault struct {
+	Kind      string                     `json:"kind"`
+	Direction controller.ThermoDirection `json:"direction"`
+	Detected  time.Time                  `json:"detected"`
+	// Change is how far the temperature moved in the right direction over the last Diagnostics.After.
+	Change  float64 `json:"change"`
+	CutOff  bool    `json:"cutOff"`
+	Message string  `json:"message"`
+}
+
+// checkpoint is the reading a run of heating or cooling is measured against.
+type checkpoint struct {
+	direction controller.ThermoDirection
+	time      time.Time
+	temp      float64
+}
+
+// Fault returns the current fault of the HVAC system, if any.
+func (stat *Thermostat) Fault() *Fault {
+	stat.mu.RLock()
+	defer stat.mu.RUnlock()
+	return stat.fault
+}
+
+// ClearFault forgets the current fault so that a cut off system may heat or cool again.  It returns ErrNotFound if
+// there is no fault.
+func (stat *Thermostat) ClearFault() error {
+	stat.mu.Lock()
+	defer stat.mu.Unlock()
+
+	if stat.fault == nil {
+		return ErrNotFound
+	}
+	log.Println("clearing fault: " + stat.fault.Kind)
+	stat.fault, stat.checkpoint = nil, nil
+	stat.publish(StreamFault, (*Fault)(nil))
+	return nil
+}
+
+// faultBlocks reports whether a cut off fault keeps the system from running in direction.  Must be called with mu held.
+func (stat *Thermostat) faultBlocks(direction controller.ThermoDirection) bool {
+	return stat.fault != nil && stat.fault.CutOff && stat.fault.Direction == direction
+}
+
+// diagnose compares temp to the last checkpoint of the current run of heating or cooling every Diagnostics.After and
+// raises a Fault if the temperature did not follow.  Faults that didn't cut the system off clear themselves once the
+// run ends or becomes effective.  Must be called with mu held.
+func (stat *Thermostat) diagnose(temp float64, now time.Time) {
+	direction := stat.control.Direction()
+	if stat.Diagnostics == nil || (direction != controller.Heating && direction != controller.Cooling) {
+		stat.checkpoint = nil
+		stat.resolveFault()
+		return
+	}
+
+	if stat.checkpoint == nil || stat.checkpoint.direction != direction {
+		stat.checkpoint = &checkpoint{direction: direction, time: now, temp: temp}
+		return
+	}
+	if now.Sub(stat.checkpoint.time) < time.Duration(stat.Diagnostics.After) {
+		return
+	}
+
+	change := temp - stat.checkpoint.temp
+	if direction == controller.Cooling {
+		change = -change
+	}
+	stat.checkpoint = &checkpoint{direction: direction, time: now, temp: temp}
+
+	if change >= stat.Diagnostics.MinChange {
+		stat.resolveFault()
+		return
+	}
+	if stat.fault != nil {
+		return
+	}
+
+	kind := FaultHeatingIneffective
+	if direction == controller.Cooling {
+		kind = FaultCoolingIneffective
+	}
+	stat.fault = &Fault{
+		Kind:      kind,
+		Direction: direction,
+		Detected:  now,
+		Change:    change,
+		CutOff:    stat.Diagnostics.CutOff,
+		Message:   fmt.Sprintf("%s: temperature changed by %.1f° %s in %s", kind, change, stat.UnitPreference, stat.Diagnostics.After),
+	}
+	log.Println("FAULT: " + stat.fault.Message)
+	stat.Events.Add(&util.EventLog{AmbientTemperature: stat.temperature(temp), Direction: direction, Message: stat.fault.Message})
+	stat.publish(StreamFault, stat.fault)
+
+	if stat.fault.CutOff {
+		log.Println("turning OFF")
+		stat.control.Off()
+		stat.LastFan = now
+	}
+}
+
+// resolveFault clears a fault that did not cut the system off.  Must be called with mu held.
+func (stat *Thermostat) resolveFault() {
+	if stat.fault == nil || stat.fault.CutOff {
+		return
+	}
+	log.Println("resolved fault: " + stat.fault.Kind)
+	stat.fault = nil
+	stat.publish(StreamFault, (*Fault)(nil))
+}
@@ -0,0 +1,143 @@
+package thermostat
+
+import (
+	"log"
+	"time"
+
+	"github.com/alittlebrighter/thermostat/controller"
+	tmeter "github.com/alittlebrighter/thermostat/thermometer"
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+// FailurePolicy decides when failed readings put the thermostat in a degraded state and what it does about them.
+// The thermostat degrades once more than MaxErrors readings failed and recovers with the first good reading.
+type FailurePolicy struct {
+	// Window counts the readings that failed within this long, good readings in between or not, instead of the ones
+	// that failed in a row.
+	Window util.Duration `json:"window"`
+	// FreezeProtection keeps some heat going while no thermometer can be read in the heating season.
+	FreezeProtection *FreezeProtection `json:"freezeProtection"`
+}
+
+// FreezeProtection heats for On every Period while degraded if the thermostat was heating or the last good reading
+// was below Below degrees of UnitPreference when the thermometers were lost.
+type FreezeProtection struct {
+	Below  float64       `json:"below"`
+	On     util.Duration `json:"on"`
+	Period util.Duration `json:"period"`
+}
+
+// What a degraded thermostat falls back to.
+const (
+	FallbackSecondary        = "secondary thermometer"
+	FallbackFreezeProtection = "freeze protection"
+	FallbackOff              = "off"
+)
+
+// Degraded describes a thermostat that can't read its thermometer.
+type Degraded struct {
+	Since    time.Time `json:"since"`
+	Fallback string    `json:"fallback"`
+
+	// heatingSeason records whether freeze protection applies.
+	heatingSeason bool
+}
+
+// SetSecondaryThermometer sets a thermometer to fall back to while the primary one can't be read.
+func (stat *Thermostat) SetSecondaryThermometer(t tmeter.Thermometer) {
+	stat.mu.Lock()
+	defer stat.mu.Unlock()
+	stat.secondary = t
+}
+
+// Degraded returns the degraded state of the thermostat, or nil if its thermometer is working.
+func (stat *Thermostat) Degraded() *Degraded {
+	stat.mu.RLock()
+	defer stat.mu.RUnlock()
+	return stat.degraded
+}
+
+// countError records a failed reading at now and returns the number of failures that count towards MaxErrors.  Must
+// be called with mu held.
+func (stat *Thermostat) countError(now time.Time) int {
+	if stat.Failure == nil || stat.Failure.Window <= 0 {
+		stat.errorTimes = nil
+		stat.errorCount++
+		return stat.errorCount
+	}
+
+	recent := stat.errorTimes[:0]
+	for _, t := range stat.errorTimes {
+		if now.Sub(t) < time.Duration(stat.Failure.Window) {
+			recent = append(recent, t)
+		}
+	}
+	stat.errorTimes = append(recent, now)
+	return len(stat.errorTimes)
+}
+
+// fallBack degrades the thermostat, or keeps it degraded, and applies the best fallback available.  Must be called
+// with mu held.
+func (stat *Thermostat) fallBack(now time.Time) {
+	degraded := stat.degraded
+	if degraded == nil {
+		degraded = &Degraded{Since: now}
+		if protection := stat.freezeProtection(); protection != nil {
+			degraded.heatingSeason = stat.control.Direction() == controller.Heating ||
+				(stat.lastAccepted != nil && stat.lastAccepted.temp < protection.Below)
+		}
+	}
+
+	fallback := FallbackOff
+	switch {
+	case stat.secondary != nil && !stat.secondaryDown:
+		fallback = FallbackSecondary
+	case degraded.heatingSeason && stat.freezeProtection() != nil:
+		fallback = FallbackFreezeProtection
+	}
+
+	if stat.degraded == nil || stat.degraded.Fallback != fallback {
+		log.Printf("DEGRADED: falling back to %s", fallback)
+		stat.degraded = &Degraded{Since: degraded.Since, Fallback: fallback, heatingSeason: degraded.heatingSeason}
+		stat.Events.Add(&util.EventLog{AmbientTemperature: stat.temperature(-1), Direction: stat.control.Direction(), Message: "degraded, falling back to " + fallback})
+		stat.publish(StreamDegraded, stat.degraded)
+	}
+
+	switch fallback {
+	case FallbackFreezeProtection:
+		protection := stat.freezeProtection()
+		if now.Sub(stat.degraded.Since)%time.Duration(protection.Period) < time.Duration(protection.On) {
+			if stat.control.Direction() != controller.Heating {
+				log.Println("turning on HEAT for freeze protection")
+				stat.control.Heat()
+			}
+		} else if stat.control.Direction() != controller.None {
+			log.Println("turning OFF")
+			stat.control.Off()
+		}
+	case FallbackOff:
+		if stat.control.Direction() != controller.None {
+			log.Println("turning OFF")
+			stat.control.Off()
+		}
+	}
+}
+
+func (stat *Thermostat) freezeProtection() *FreezeProtection {
+	if stat.Failure == nil {
+		return nil
+	}
+	return stat.Failure.FreezeProtection
+}
+
+// recovered ends the degraded state and the streak of failed readings after a good one.  Must be called with mu held.
+func (stat *Thermostat) recovered() {
+	stat.errorCount = 0
+	if stat.degraded == nil {
+		return
+	}
+
+	log.Println("recovered, thermometer readable again")
+	stat.degraded = nil
+	stat.publish(StreamDegraded, (*Degraded)(nil))
+}
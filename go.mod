@@ -4,7 +4,9 @@ go 1.15
 
 require (
 	github.com/alittlebrighter/embd v1.0.0
+	github.com/eclipse/paho.mqtt.golang v1.4.3
 	github.com/ghodss/yaml v1.0.0
 	github.com/stianeikeland/go-rpio v4.2.0+incompatible
+	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e
 	gopkg.in/yaml.v2 v2.3.0 // indirect
 )
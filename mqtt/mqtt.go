@@ -0,0 +1,311 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alittlebrighter/thermostat"
+	"github.com/alittlebrighter/thermostat/controller"
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+// ErrNotConnected is returned by a Client that can't reach the broker right now.
+var ErrNotConnected = errors.New("not connected to the MQTT broker")
+
+// Client is the part of an MQTT client the Bridge needs.  Publish and Subscribe fail with ErrNotConnected while the
+// client is not connected.
+type Client interface {
+	Publish(topic string, retained bool, payload []byte) error
+	Subscribe(topic string, handler func(topic string, payload []byte)) error
+	// OnConnect registers handler to be called every time a connection to the broker is established, and right away if
+	// one already is.  After a reconnect the broker has forgotten the subscriptions and may have published the will.
+	OnConnect(handler func())
+	Disconnect()
+}
+
+// Config defines how to reach the MQTT broker and where to publish.
+type Config struct {
+	// Broker is the URL of the broker, e.g. tcp://homeassistant.local:1883.  MQTT is disabled without one.
+	Broker   string         `json:"broker"`
+	ClientID string         `json:"clientId"`
+	Username string         `json:"username"`
+	Password string         `json:"password"`
+	TLS      util.TLSConfig `json:"tls"`
+	// TopicPrefix is prepended to every state and command topic, "thermostat" by default.
+	TopicPrefix string `json:"topicPrefix"`
+	// DiscoveryPrefix is where Home Assistant looks for discovery payloads, "homeassistant" by default.
+	DiscoveryPrefix string `json:"discoveryPrefix"`
+	// NodeID identifies this thermostat to Home Assistant, "thermostat" by default.
+	NodeID string `json:"nodeId"`
+}
+
+// Enabled reports whether a broker is configured.
+func (c *Config) Enabled() bool {
+	return c != nil && c.Broker != ""
+}
+
+func (c *Config) topic(name string) string {
+	prefix := c.TopicPrefix
+	if prefix == "" {
+		prefix = "thermostat"
+	}
+	return prefix + "/" + name
+}
+
+func (c *Config) nodeID() string {
+	if c.NodeID == "" {
+		return "thermostat"
+	}
+	return c.NodeID
+}
+
+func (c *Config) discoveryTopic() string {
+	prefix := c.DiscoveryPrefix
+	if prefix == "" {
+		prefix = "homeassistant"
+	}
+	return prefix + "/climate/" + c.nodeID() + "/config"
+}
+
+// AvailabilityTopic is where the bridge publishes "online" and the broker publishes "offline" when the bridge
+// disconnects unexpectedly.
+func (c *Config) AvailabilityTopic() string {
+	return c.topic("availability")
+}
+
+const (
+	online  = "online"
+	offline = "offline"
+	// mqttAuthor is who configuration changes made over MQTT are attributed to.
+	mqttAuthor = "mqtt"
+)
+
+// Bridge publishes the state of a thermostat to MQTT and applies the commands it receives.
+//
+// State topics (retained): temperature, target_low, target_high, mode (the active mode), hold, direction, action
+// (direction in Home Assistant's terms) and hvac_mode.  Command topics: mode/set holds a mode indefinitely,
+// hold/set takes a duration to hold the active mode for or "off" to go back to the schedule, and target_low/set and
+// target_high/set change the window of the active mode.
+type Bridge struct {
+	client     Client
+	thermostat *thermostat.Thermostat
+	config     *Config
+}
+
+// NewBridge creates a Bridge between stat and the broker client is connected to.
+func NewBridge(client Client, stat *thermostat.Thermostat, config *Config) *Bridge {
+	return &Bridge{client: client, thermostat: stat, config: config}
+}
+
+// Run publishes every change published on stream until ctx is done.  Every time the client connects, including
+// when the broker only becomes reachable after the thermostat started, it subscribes to the command topics,
+// announces the thermostat to Home Assistant and publishes the current state.
+func (b *Bridge) Run(ctx context.Context, stream *util.Stream) {
+	b.client.OnConnect(func() {
+		if ctx.Err() != nil {
+			return
+		}
+		log.Println("Connected to the MQTT broker.")
+		if err := b.subscribe(); err != nil {
+			log.Println("Error subscribing to MQTT commands: " + err.Error())
+		}
+		b.publishDiscovery()
+		b.publish("availability", online)
+		b.publishState()
+	})
+	defer b.client.OnConnect(nil)
+
+	_, events, unsubscribe := stream.Subscribe(util.StreamLive)
+	defer func() { unsubscribe() }()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// dropped for falling behind, catch up and listen again
+				_, events, unsubscribe = stream.Subscribe(util.StreamLive)
+				b.publishState()
+				continue
+			}
+			if event.Type == thermostat.StreamConfig {
+				b.publishDiscovery()
+			}
+			b.publishState()
+		case <-ctx.Done():
+			b.publish("availability", offline)
+			return
+		}
+	}
+}
+
+// subscribe subscribes to the command topics.
+func (b *Bridge) subscribe() error {
+	commands := map[string]func(string) error{
+		"mode/set":        b.setMode,
+		"hold/set":        b.setHold,
+		"target_low/set":  b.setTarget(false),
+		"target_high/set": b.setTarget(true),
+	}
+	for name, command := range commands {
+		name, command := name, command
+		err := b.client.Subscribe(b.config.topic(name), func(topic string, payload []byte) {
+			if err := command(strings.TrimSpace(string(payload))); err != nil {
+				log.Printf("Error handling MQTT command %s: %s", name, err.Error())
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bridge) publish(name, payload string) {
+	// while disconnected there's nothing to do, the state is published again on connect
+	if err := b.client.Publish(b.config.topic(name), true, []byte(payload)); err != nil && err != ErrNotConnected {
+		log.Printf("Error publishing to MQTT topic %s: %s", name, err.Error())
+	}
+}
+
+func (b *Bridge) publishState() {
+	status := b.thermostat.Status()
+
+	if status.LastReading != nil && status.LastReading.Message == "" {
+		b.publish("temperature", formatTemp(status.LastReading.AmbientTemperature, status.UnitPreference))
+	}
+	if status.Window != nil {
+		b.publish("target_low", formatTemp(status.Window.Low, status.UnitPreference))
+		b.publish("target_high", formatTemp(status.Window.High, status.UnitPreference))
+	}
+	b.publish("mode", status.Mode)
+	if status.Hold != nil {
+		hold, _ := json.Marshal(status.Hold)
+		b.publish("hold", string(hold))
+	} else {
+		b.publish("hold", "off")
+	}
+	b.publish("direction", status.Direction.String())
+	b.publish("action", haAction(status.Direction))
+	b.publish("hvac_mode", "heat_cool")
+}
+
+func (b *Bridge) publishDiscovery() {
+	snapshot := b.thermostat.Copy()
+	presets := make([]string, 0, len(snapshot.Modes))
+	for name := range snapshot.Modes {
+		presets = append(presets, name)
+	}
+
+	unit := "C"
+	if haUnits(snapshot.UnitPreference) == util.Fahrenheit {
+		unit = "F"
+	}
+
+	discovery := map[string]interface{}{
+		"name":                           "Thermostat",
+		"unique_id":                      b.config.nodeID(),
+		"availability_topic":             b.config.AvailabilityTopic(),
+		"current_temperature_topic":      b.config.topic("temperature"),
+		"temperature_low_state_topic":    b.config.topic("target_low"),
+		"temperature_low_command_topic":  b.config.topic("target_low/set"),
+		"temperature_high_state_topic":   b.config.topic("target_high"),
+		"temperature_high_command_topic": b.config.topic("target_high/set"),
+		"action_topic":                   b.config.topic("action"),
+		"modes":                          []string{"heat_cool"},
+		"mode_state_topic":               b.config.topic("hvac_mode"),
+		"preset_modes":                   presets,
+		"preset_mode_state_topic":        b.config.topic("mode"),
+		"preset_mode_command_topic":      b.config.topic("mode/set"),
+		"temperature_unit":               unit,
+		"precision":                      0.1,
+		"device": map[string]interface{}{
+			"identifiers":  []string{b.config.nodeID()},
+			"name":         "Thermostat",
+			"manufacturer": "alittlebrighter",
+			"model":        "thermostat",
+		},
+	}
+
+	payload, err := json.Marshal(discovery)
+	if err != nil {
+		log.Println("Error marshalling Home Assistant discovery: " + err.Error())
+		return
+	}
+	if err := b.client.Publish(b.config.discoveryTopic(), true, payload); err != nil {
+		log.Println("Error publishing Home Assistant discovery: " + err.Error())
+	}
+}
+
+func (b *Bridge) setMode(mode string) error {
+	return b.thermostat.SetHold(&thermostat.Change{Author: mqttAuthor, Revision: thermostat.AnyRevision}, &thermostat.Hold{Mode: mode})
+}
+
+func (b *Bridge) setHold(payload string) error {
+	change := &thermostat.Change{Author: mqttAuthor, Revision: thermostat.AnyRevision}
+
+	switch strings.ToLower(payload) {
+	case "", "off", "none", "schedule":
+		return b.thermostat.SetHold(change, nil)
+	}
+
+	duration, err := time.ParseDuration(payload)
+	if err != nil {
+		return fmt.Errorf("hold must be a duration or off: %s", err.Error())
+	}
+	return b.thermostat.SetHold(change, &thermostat.Hold{Mode: b.thermostat.Status().Mode, Until: time.Now().Add(duration)})
+}
+
+func (b *Bridge) setTarget(high bool) func(string) error {
+	return func(payload string) error {
+		temp, err := strconv.ParseFloat(payload, 64)
+		if err != nil {
+			return err
+		}
+
+		status := b.thermostat.Status()
+		if status.Window == nil {
+			return thermostat.ErrNotFound
+		}
+		window := *status.Window.In(haUnits(status.UnitPreference))
+		if high {
+			window.High.Degrees = temp
+		} else {
+			window.Low.Degrees = temp
+		}
+
+		_, err = b.thermostat.SetMode(&thermostat.Change{Author: mqttAuthor, Revision: thermostat.AnyRevision}, status.Mode, &window)
+		return err
+	}
+}
+
+// formatTemp formats temp for Home Assistant, which is sent temperatures in units.  Temperatures that don't say what
+// units they are in are taken to be in units.
+func formatTemp(temp util.Temperature, units util.TemperatureUnits) string {
+	if temp.Units == "" {
+		temp.Units = units
+	}
+	return strconv.FormatFloat(temp.In(haUnits(units)).Degrees, 'f', 1, 64)
+}
+
+// haUnits returns the units temperatures are exchanged with Home Assistant in, which only knows Celsius and
+// Fahrenheit.
+func haUnits(preference util.TemperatureUnits) util.TemperatureUnits {
+	if preference == util.Fahrenheit {
+		return util.Fahrenheit
+	}
+	return util.Celsius
+}
+
+// haAction translates a direction into a Home Assistant hvac_action.
+func haAction(direction controller.ThermoDirection) string {
+	if direction == controller.None {
+		return "idle"
+	}
+	return direction.String()
+}
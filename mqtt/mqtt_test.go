@@ -0,0 +1,166 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alittlebrighter/thermostat"
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+func TestBridge(t *testing.T) {
+	stat := &thermostat.Thermostat{
+		Modes:          map[string]*thermostat.Window{"default": thermostat.NewWindow(69, 80, ""), "away": thermostat.NewWindow(60, 85, "")},
+		DefaultMode:    "default",
+		UnitPreference: util.Fahrenheit,
+		Events:         util.NewRingBuffer(1),
+	}
+	stream := util.NewStream(10)
+	stat.SetStream(stream)
+
+	// the broker is unreachable when the bridge starts
+	broker := newBroker()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewBridge(broker, stat, &Config{Broker: "in-process"}).Run(ctx, stream)
+	}()
+	if _, err := stat.SetMode(&thermostat.Change{Author: "test", Revision: thermostat.AnyRevision}, "default", thermostat.NewWindow(68, 80, "")); err != nil {
+		t.Fatal(err)
+	}
+
+	broker.connect()
+	broker.waitFor(t, "thermostat/availability", "online")
+	broker.waitFor(t, "thermostat/target_low", "68.0")
+
+	discovery := new(struct {
+		PresetModes []string `json:"preset_modes"`
+		Unit        string   `json:"temperature_unit"`
+	})
+	if err := json.Unmarshal(broker.retained("homeassistant/climate/thermostat/config"), discovery); err != nil {
+		t.Fatal("No Home Assistant discovery payload: " + err.Error())
+	}
+	if len(discovery.PresetModes) != 2 || discovery.Unit != "F" {
+		t.Error("Discovery payload does not describe the thermostat.")
+	}
+
+	broker.Publish("thermostat/mode/set", false, []byte("away"))
+	broker.waitFor(t, "thermostat/mode", "away")
+	broker.waitFor(t, "thermostat/target_low", "60.0")
+
+	broker.Publish("thermostat/target_high/set", false, []byte("88"))
+	broker.waitFor(t, "thermostat/target_high", "88.0")
+	if window, _ := stat.Mode("away"); window.High.Degrees != 88 || window.Low.Degrees != 60 {
+		t.Error("Setpoint command did not change the active mode.")
+	}
+
+	broker.Publish("thermostat/hold/set", false, []byte("off"))
+	broker.waitFor(t, "thermostat/mode", "default")
+
+	// the connection dropped and came back with a clean session
+	broker.drop()
+	broker.connect()
+	broker.waitFor(t, "thermostat/availability", "online")
+	broker.Publish("thermostat/mode/set", false, []byte("away"))
+	broker.waitFor(t, "thermostat/mode", "away")
+
+	cancel()
+	<-done
+	if string(broker.retained("thermostat/availability")) != "offline" {
+		t.Error("Bridge did not announce that it went offline.")
+	}
+}
+
+// broker is an in-process stand-in for an MQTT broker that delivers messages to exact topic subscriptions.  It
+// starts out unreachable.
+type broker struct {
+	mu        sync.Mutex
+	connected bool
+	messages  map[string][]byte
+	handlers  map[string][]func(string, []byte)
+	onConnect func()
+}
+
+func newBroker() *broker {
+	return &broker{messages: make(map[string][]byte), handlers: make(map[string][]func(string, []byte))}
+}
+
+func (b *broker) Publish(topic string, retained bool, payload []byte) error {
+	b.mu.Lock()
+	if !b.connected {
+		b.mu.Unlock()
+		return ErrNotConnected
+	}
+	b.messages[topic] = payload
+	handlers := append([]func(string, []byte){}, b.handlers[topic]...)
+	b.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(topic, payload)
+	}
+	return nil
+}
+
+func (b *broker) Subscribe(topic string, handler func(string, []byte)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.connected {
+		return ErrNotConnected
+	}
+	b.handlers[topic] = append(b.handlers[topic], handler)
+	return nil
+}
+
+func (b *broker) OnConnect(handler func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onConnect = handler
+	if handler != nil && b.connected {
+		go handler()
+	}
+}
+
+func (b *broker) Disconnect() {}
+
+// connect lets the client know it is connected.
+func (b *broker) connect() {
+	b.mu.Lock()
+	b.connected = true
+	handler := b.onConnect
+	b.mu.Unlock()
+
+	if handler != nil {
+		go handler()
+	}
+}
+
+// drop drops the subscriptions and publishes the will, as a broker does when a client with a clean session loses its
+// connection.
+func (b *broker) drop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.connected = false
+	b.handlers = make(map[string][]func(string, []byte))
+	b.messages["thermostat/availability"] = []byte(offline)
+}
+
+func (b *broker) retained(topic string) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.messages[topic]
+}
+
+func (b *broker) waitFor(t *testing.T, topic, payload string) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if string(b.retained(topic)) == payload {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%s never became %q, last was %q", topic, payload, b.retained(topic))
+}
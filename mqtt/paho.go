@@ -0,0 +1,99 @@
+package mqtt
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// timeout bounds how long we wait for the broker to acknowledge anything.
+const timeout = 10 * time.Second
+
+var errTimeout = errors.New("timed out waiting for the MQTT broker")
+
+type pahoClient struct {
+	client paho.Client
+
+	mu        sync.Mutex
+	onConnect func()
+}
+
+// Connect starts connecting to the broker in config.  It doesn't wait for the broker: the connection is retried in the
+// background until it succeeds, so that the thermostat keeps running while the broker is unreachable, and is
+// re-established automatically, with a clean session, whenever it drops.  The handler passed to OnConnect makes the
+// subscriptions each time.  The broker marks the thermostat unavailable if the connection drops.
+func Connect(config *Config) (Client, error) {
+	c := new(pahoClient)
+	opts := paho.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetConnectRetry(true).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(c.connected).
+		SetWill(config.AvailabilityTopic(), offline, 1, true)
+
+	tlsConfig, err := config.TLS.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	c.client = paho.NewClient(opts)
+	c.client.Connect()
+	return c, nil
+}
+
+// connected is called by paho, in a goroutine of its own, every time it connects.
+func (c *pahoClient) connected(paho.Client) {
+	c.mu.Lock()
+	handler := c.onConnect
+	c.mu.Unlock()
+
+	if handler != nil {
+		handler()
+	}
+}
+
+func (c *pahoClient) OnConnect(handler func()) {
+	c.mu.Lock()
+	c.onConnect = handler
+	c.mu.Unlock()
+
+	if handler != nil && c.client.IsConnectionOpen() {
+		go handler()
+	}
+}
+
+// Publish and Subscribe fail right away while disconnected, rather than waiting for paho to connect or time out.
+func (c *pahoClient) Publish(topic string, retained bool, payload []byte) error {
+	if !c.client.IsConnectionOpen() {
+		return ErrNotConnected
+	}
+	return wait(c.client.Publish(topic, 1, retained, payload))
+}
+
+func (c *pahoClient) Subscribe(topic string, handler func(topic string, payload []byte)) error {
+	if !c.client.IsConnectionOpen() {
+		return ErrNotConnected
+	}
+	return wait(c.client.Subscribe(topic, 1, func(_ paho.Client, msg paho.Message) {
+		handler(msg.Topic(), msg.Payload())
+	}))
+}
+
+func (c *pahoClient) Disconnect() {
+	c.client.Disconnect(uint(timeout / time.Millisecond))
+}
+
+func wait(token paho.Token) error {
+	if !token.WaitTimeout(timeout) {
+		return errTimeout
+	}
+	return token.Error()
+}
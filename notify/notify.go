@@ -0,0 +1,291 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/alittlebrighter/thermostat"
+	"github.com/alittlebrighter/thermostat/controller"
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+// Condition is what a Rule watches for.
+type Condition string
+
+const (
+	// OutOfWindow fires when readings stay outside of the active mode's window for longer than the rule's For.
+	OutOfWindow Condition = "outOfWindow"
+	// MaxErrors fires every time too many failed readings degrade the thermostat or change what it falls back to, and
+	// again once it recovers.
+	MaxErrors Condition = "maxErrors"
+	// LongRun fires when heating or cooling has been running for longer than the rule's For.
+	LongRun Condition = "longRun"
+	// SensorUnreachable fires when readings keep failing for longer than the rule's For.
+	SensorUnreachable Condition = "sensorUnreachable"
+	// ConfigChanged fires on every accepted configuration change.
+	ConfigChanged Condition = "configChanged"
+	// Fault fires when diagnostics find a fault with the HVAC system and again once it is cleared.
+	Fault Condition = "fault"
+	// Safety fires when the temperature goes beyond the thermostat's safety limits and again once it is back within.
+	Safety Condition = "safety"
+)
+
+// Config lists where notifications can be sent and the rules deciding when to send them.
+type Config struct {
+	Targets []*Target `json:"targets"`
+	Rules   []*Rule   `json:"rules"`
+}
+
+// Rule sends a Notification to Targets when Condition has held for For.  OutOfWindow, LongRun and SensorUnreachable
+// notify once when they start and once more when they are resolved.
+type Rule struct {
+	Name      string        `json:"name"`
+	Condition Condition     `json:"condition"`
+	For       util.Duration `json:"for"`
+	Targets   []string      `json:"targets"`
+}
+
+// Notification is the JSON payload POSTed to the targets of a rule.
+type Notification struct {
+	Rule      string             `json:"rule"`
+	Condition Condition          `json:"condition"`
+	Resolved  bool               `json:"resolved"`
+	Time      time.Time          `json:"time"`
+	Message   string             `json:"message"`
+	Status    *thermostat.Status `json:"status"`
+}
+
+// Enabled reports whether any rules are configured.
+func (c *Config) Enabled() bool {
+	return c != nil && len(c.Rules) > 0
+}
+
+// Validate checks that every rule has a known condition and only refers to configured targets.
+func (c *Config) Validate() error {
+	targets := make(map[string]bool, len(c.Targets))
+	for _, target := range c.Targets {
+		if target.Name == "" || target.URL == "" {
+			return errors.New("notification targets need a name and a url")
+		}
+		targets[target.Name] = true
+	}
+
+	for _, rule := range c.Rules {
+		switch rule.Condition {
+		case OutOfWindow, MaxErrors, LongRun, SensorUnreachable, ConfigChanged, Fault, Safety:
+		default:
+			return fmt.Errorf("rule %s has unknown condition %q", rule.Name, rule.Condition)
+		}
+		if len(rule.Targets) == 0 {
+			return fmt.Errorf("rule %s has no targets", rule.Name)
+		}
+		for _, name := range rule.Targets {
+			if !targets[name] {
+				return fmt.Errorf("rule %s refers to unknown target %s", rule.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Notifier watches what a thermostat publishes and notifies the targets of every rule whose condition is met.
+// Lasting conditions are checked on every event, so they are noticed at most one PollInterval late.
+type Notifier struct {
+	thermostat *thermostat.Thermostat
+	rules      []*ruleState
+	targets    map[string]*webhook
+	deliveries sync.WaitGroup
+}
+
+// ruleState tracks the current episode of a lasting condition.
+type ruleState struct {
+	*Rule
+	since time.Time
+	fired bool
+}
+
+// NewNotifier creates a Notifier for stat.  config must be valid.
+func NewNotifier(stat *thermostat.Thermostat, config *Config) *Notifier {
+	n := &Notifier{thermostat: stat, targets: make(map[string]*webhook, len(config.Targets))}
+	for _, target := range config.Targets {
+		n.targets[target.Name] = newWebhook(target)
+	}
+	for _, rule := range config.Rules {
+		n.rules = append(n.rules, &ruleState{Rule: rule})
+	}
+	return n
+}
+
+// Run evaluates the rules against every event published on stream until ctx is done and then waits for deliveries
+// still being retried to give up.
+func (n *Notifier) Run(ctx context.Context, stream *util.Stream) {
+	_, events, unsubscribe := stream.Subscribe(util.StreamLive)
+	defer func() {
+		unsubscribe()
+		n.deliveries.Wait()
+	}()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// dropped for falling behind, listen again
+				_, events, unsubscribe = stream.Subscribe(util.StreamLive)
+				continue
+			}
+			n.handle(ctx, event)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (n *Notifier) handle(ctx context.Context, event *util.StreamEvent) {
+	status := n.thermostat.Status()
+	for _, rule := range n.rules {
+		if notification := rule.evaluate(event, status); notification != nil {
+			n.send(ctx, rule.Rule, notification)
+		}
+	}
+}
+
+func (n *Notifier) send(ctx context.Context, rule *Rule, notification *Notification) {
+	for _, name := range rule.Targets {
+		target := n.targets[name]
+		n.deliveries.Add(1)
+		go func() {
+			defer n.deliveries.Done()
+			if err := target.deliver(ctx, notification); err != nil {
+				log.Printf("Error notifying %s of %s: %s", target.Name, rule.Name, err.Error())
+			}
+		}()
+	}
+}
+
+// evaluate returns the Notification to send for event, if any.
+func (rule *ruleState) evaluate(event *util.StreamEvent, status *thermostat.Status) *Notification {
+	notification := &Notification{Rule: rule.Name, Condition: rule.Condition, Time: event.Time, Status: status}
+
+	switch rule.Condition {
+	case MaxErrors:
+		if event.Type != thermostat.StreamDegraded {
+			return nil
+		}
+		if degraded, ok := event.Data.(*thermostat.Degraded); ok && degraded != nil {
+			notification.Message = "Too many failed temperature readings, falling back to " + degraded.Fallback + "."
+		} else {
+			notification.Resolved = true
+			notification.Message = "The thermometer is readable again."
+		}
+		return notification
+	case ConfigChanged:
+		entry, ok := event.Data.(*thermostat.AuditEntry)
+		if event.Type != thermostat.StreamConfig || !ok {
+			return nil
+		}
+		notification.Message = fmt.Sprintf("%s %s (revision %d).", entry.Author, entry.Action, entry.Revision)
+		return notification
+	case Fault:
+		if event.Type != thermostat.StreamFault {
+			return nil
+		}
+		if fault, ok := event.Data.(*thermostat.Fault); ok && fault != nil {
+			notification.Message = fault.Message + "."
+			if fault.CutOff {
+				notification.Message += " The HVAC system has been turned off until the fault is cleared."
+			}
+		} else {
+			notification.Resolved = true
+			notification.Message = "The HVAC fault has been cleared."
+		}
+		return notification
+	case Safety:
+		if event.Type != thermostat.StreamSafety {
+			return nil
+		}
+		if breach, ok := event.Data.(*thermostat.SafetyBreach); ok && breach != nil {
+			notification.Message = fmt.Sprintf("It is %.1f° %s, beyond the safety limit of %.1f, %s.", breach.Temperature,
+				status.UnitPreference, breach.Limit, breach.Direction)
+		} else {
+			notification.Resolved = true
+			notification.Message = "The temperature is back within the safety limits."
+		}
+		return notification
+	case OutOfWindow:
+		reading, ok := event.Data.(*util.EventLog)
+		if event.Type != thermostat.StreamReading || !ok || status.Window == nil {
+			return nil
+		}
+		temp := reading.AmbientTemperature.Degrees
+		out := temp < status.Window.Low.Degrees || temp > status.Window.High.Degrees
+		if !rule.observe(out, event.Time, event.Time) {
+			return nil
+		}
+		if out {
+			notification.Message = fmt.Sprintf("It is %.1f° %s, outside of %.1f-%.1f for %s since %s.", temp,
+				reading.AmbientTemperature.Units, status.Window.Low.Degrees, status.Window.High.Degrees, status.Mode, rule.since.Format(time.Kitchen))
+		} else {
+			notification.Resolved = true
+			notification.Message = fmt.Sprintf("It is %.1f° %s, back within the %s window.", temp, reading.AmbientTemperature.Units, status.Mode)
+		}
+		return notification
+	case LongRun:
+		running := status.Direction == controller.Heating || status.Direction == controller.Cooling
+		if !rule.observe(running, status.Since, event.Time) {
+			return nil
+		}
+		if running {
+			notification.Message = fmt.Sprintf("The HVAC system has been %s since %s.", status.Direction, status.Since.Format(time.Kitchen))
+		} else {
+			notification.Resolved = true
+			notification.Message = fmt.Sprintf("The HVAC system is %s again.", status.Direction)
+		}
+		return notification
+	case SensorUnreachable:
+		var failing bool
+		switch event.Type {
+		case thermostat.StreamError:
+			failing = true
+		case thermostat.StreamReading:
+		default:
+			return nil
+		}
+		if !rule.observe(failing, event.Time, event.Time) {
+			return nil
+		}
+		if failing {
+			notification.Message = "The thermometer has not been readable since " + rule.since.Format(time.Kitchen) + "."
+			if reading, ok := event.Data.(*util.EventLog); ok {
+				notification.Message += " " + reading.Message
+			}
+		} else {
+			notification.Resolved = true
+			notification.Message = "The thermometer is readable again."
+		}
+		return notification
+	}
+	return nil
+}
+
+// observe tracks a lasting condition that has been active since start and reports whether it just crossed the rule's
+// For, or was resolved after having been reported.
+func (rule *ruleState) observe(active bool, start, now time.Time) bool {
+	if !active {
+		fired := rule.fired
+		rule.since, rule.fired = time.Time{}, false
+		return fired
+	}
+
+	if rule.since.IsZero() {
+		rule.since = start
+	}
+	if !rule.fired && now.Sub(rule.since) >= time.Duration(rule.For) {
+		rule.fired = true
+		return true
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alittlebrighter/thermostat"
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+func TestRules(t *testing.T) {
+	stat := &thermostat.Thermostat{
+		Modes:          map[string]*thermostat.Window{"default": thermostat.NewWindow(60, 80, "")},
+		DefaultMode:    "default",
+		UnitPreference: util.Fahrenheit,
+	}
+
+	received := make(chan *Notification, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notification := new(Notification)
+		if err := json.NewDecoder(r.Body).Decode(notification); err != nil {
+			t.Error("Notification is not JSON: " + err.Error())
+		}
+		received <- notification
+	}))
+	defer server.Close()
+
+	config := &Config{
+		Targets: []*Target{{Name: "phone", URL: server.URL}},
+		Rules: []*Rule{
+			{Name: "freezing", Condition: OutOfWindow, For: util.Duration(30 * time.Minute), Targets: []string{"phone"}},
+			{Name: "sensor", Condition: SensorUnreachable, Targets: []string{"phone"}},
+		},
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	n := NewNotifier(stat, config)
+
+	start := time.Now()
+	reading := func(temp float64, after time.Duration) *util.StreamEvent {
+		return &util.StreamEvent{Type: thermostat.StreamReading, Time: start.Add(after), Data: &util.EventLog{AmbientTemperature: util.Temperature{Degrees: temp, Units: util.Fahrenheit}}}
+	}
+
+	n.handle(context.Background(), reading(55, 0))
+	n.handle(context.Background(), reading(54, 20*time.Minute))
+	n.handle(context.Background(), reading(53, 30*time.Minute))
+	n.handle(context.Background(), reading(52, 40*time.Minute))
+	if notification := <-received; notification.Rule != "freezing" || notification.Resolved {
+		t.Errorf("Expected a freezing notification, got %+v", notification)
+	}
+
+	n.handle(context.Background(), &util.StreamEvent{Type: thermostat.StreamError, Time: start.Add(50 * time.Minute), Data: &util.EventLog{Message: "timeout"}})
+	if notification := <-received; notification.Rule != "sensor" || notification.Resolved {
+		t.Errorf("Expected a sensor notification, got %+v", notification)
+	}
+
+	n.handle(context.Background(), reading(65, time.Hour))
+	n.deliveries.Wait()
+	close(received)
+
+	resolved := make(map[string]bool)
+	for notification := range received {
+		resolved[notification.Rule] = notification.Resolved
+	}
+	if !resolved["freezing"] || !resolved["sensor"] {
+		t.Errorf("Expected both rules to be resolved, got %v", resolved)
+	}
+}
+
+func TestDeliveryRetries(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	retries := 2
+	hook := newWebhook(&Target{Name: "phone", URL: server.URL, Retries: &retries, Backoff: util.Duration(time.Millisecond)})
+	if err := hook.deliver(context.Background(), &Notification{Rule: "test"}); err != nil {
+		t.Error("Delivery should succeed on the last retry: " + err.Error())
+	}
+
+	atomic.StoreInt32(&attempts, -10)
+	if err := hook.deliver(context.Background(), &Notification{Rule: "test"}); err == nil {
+		t.Error("Delivery should fail after running out of retries.")
+	}
+	if attempts != -7 {
+		t.Errorf("Expected 3 attempts, got %d", attempts+10)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	config := &Config{Rules: []*Rule{{Name: "freezing", Condition: OutOfWindow, Targets: []string{"phone"}}}}
+	if config.Validate() == nil {
+		t.Error("Rules must not refer to unknown targets.")
+	}
+
+	config.Targets = []*Target{{Name: "phone", URL: "https://example.com"}}
+	config.Rules[0].Condition = "hot"
+	if config.Validate() == nil {
+		t.Error("Rules must have a known condition.")
+	}
+}
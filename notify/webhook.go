@@ -0,0 +1,104 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+// Target is a webhook notifications are POSTed to as JSON.
+type Target struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Headers are added to every request, e.g. an Authorization header for a push notification service.
+	Headers map[string]string `json:"headers"`
+	// Retries is how many more times a failed delivery is attempted, 3 by default.
+	Retries *int `json:"retries"`
+	// Backoff is how long to wait before the first retry, doubling with every retry after that.  1s by default.
+	Backoff util.Duration `json:"backoff"`
+	// Timeout bounds every attempt, 10s by default.
+	Timeout util.Duration `json:"timeout"`
+}
+
+const (
+	defaultRetries = 3
+	defaultBackoff = time.Second
+	defaultTimeout = 10 * time.Second
+)
+
+type webhook struct {
+	*Target
+	client  *http.Client
+	retries int
+	backoff time.Duration
+}
+
+func newWebhook(target *Target) *webhook {
+	hook := &webhook{
+		Target:  target,
+		client:  &http.Client{Timeout: time.Duration(target.Timeout)},
+		retries: defaultRetries,
+		backoff: time.Duration(target.Backoff),
+	}
+	if target.Retries != nil {
+		hook.retries = *target.Retries
+	}
+	if hook.client.Timeout == 0 {
+		hook.client.Timeout = defaultTimeout
+	}
+	if hook.backoff == 0 {
+		hook.backoff = defaultBackoff
+	}
+	return hook
+}
+
+// deliver POSTs notification, retrying with exponential backoff on network errors, 5xx and 429 responses until it
+// succeeds, runs out of retries or ctx is done.
+func (hook *webhook) deliver(ctx context.Context, notification *Notification) error {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	backoff := hook.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := hook.post(ctx, payload)
+		if err == nil || !retry || attempt >= hook.retries {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func (hook *webhook) post(ctx context.Context, payload []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range hook.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := hook.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, fmt.Errorf("%s responded %s", hook.Name, resp.Status)
+	}
+	return false, nil
+}
@@ -0,0 +1,323 @@
+package thermostat
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/alittlebrighter/thermostat/controller"
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+var (
+	// ErrNotFound is returned when a mode or schedule event doesn't exist.
+	ErrNotFound = errors.New("not found")
+	// ErrInUse is returned when deleting a mode that is the default or is referenced by the schedule.
+	ErrInUse = errors.New("mode is in use")
+	// ErrRevisionMismatch is returned when a change was based on an outdated revision of the configuration.
+	ErrRevisionMismatch = errors.New("configuration was changed by someone else")
+)
+
+// AnyRevision skips the revision check of a Change.
+const AnyRevision uint64 = math.MaxUint64
+
+// maxAuditEntries is how many changes the audit trail remembers.
+const maxAuditEntries = 100
+
+// Change identifies who is changing the configuration and which Revision they based the change on.  Once the change
+// is accepted Revision is set to the new revision.
+type Change struct {
+	Author   string
+	Revision uint64
+}
+
+// AuditEntry records who changed the configuration, what they did and when.
+type AuditEntry struct {
+	Revision uint64    `json:"revision"`
+	Time     time.Time `json:"time"`
+	Author   string    `json:"author"`
+	Action   string    `json:"action"`
+}
+
+// InvalidError is returned when a change would leave the thermostat with an invalid configuration.
+type InvalidError string
+
+func (e InvalidError) Error() string {
+	return string(e)
+}
+
+// Status is a summary of what the thermostat is doing right now.
+type Status struct {
+	Mode           string                     `json:"mode"`
+	Hold           *Hold                      `json:"hold,omitempty"`
+	Window         *Window                    `json:"window"`
+	Direction      controller.ThermoDirection `json:"direction"`
+	Since          time.Time                  `json:"since"`
+	LastReading    *util.EventLog             `json:"lastReading"`
+	Fault          *Fault                     `json:"fault,omitempty"`
+	Degraded       *Degraded                  `json:"degraded,omitempty"`
+	SafetyBreach   *SafetyBreach              `json:"safetyBreach,omitempty"`
+	UnitPreference util.TemperatureUnits      `json:"unitPreference"`
+}
+
+// Status returns what the thermostat is doing right now.
+func (stat *Thermostat) Status() *Status {
+	stat.mu.RLock()
+	defer stat.mu.RUnlock()
+
+	now := time.Now()
+	mode := stat.currentModeName(now)
+	status := &Status{
+		Mode:           mode,
+		Window:         stat.Modes[mode].in(stat.UnitPreference, stat.UnitPreference),
+		Since:          stat.since,
+		UnitPreference: stat.UnitPreference,
+		Fault:          stat.fault,
+		Degraded:       stat.degraded,
+		SafetyBreach:   stat.breach,
+	}
+	if stat.Hold.Active(now) {
+		status.Hold = stat.Hold
+	}
+	if stat.control != nil {
+		status.Direction = stat.control.Direction()
+	}
+	if stat.Events != nil {
+		status.LastReading = stat.Events.GetLast()
+	}
+	return status
+}
+
+// Mode returns the window of the mode called name.
+func (stat *Thermostat) Mode(name string) (*Window, error) {
+	stat.mu.RLock()
+	defer stat.mu.RUnlock()
+
+	window, ok := stat.Modes[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *window
+	return &copied, nil
+}
+
+// AuditTrail returns the most recent changes to the configuration, oldest first.
+func (stat *Thermostat) AuditTrail() []*AuditEntry {
+	stat.mu.RLock()
+	defer stat.mu.RUnlock()
+	return append([]*AuditEntry{}, stat.audit...)
+}
+
+// SetChangeListener registers a function that is called with the audit entry and a snapshot of the new configuration
+// every time a change is accepted, e.g. to persist it.  It is called after the thermostat is unlocked, one change at a
+// time and in the order they were accepted, so it may take its time without holding up the control loop.
+func (stat *Thermostat) SetChangeListener(listener func(entry *AuditEntry, snapshot *Thermostat)) {
+	stat.mu.Lock()
+	defer stat.mu.Unlock()
+	stat.onChange = listener
+}
+
+// Replace replaces the whole configuration with that of update.
+func (stat *Thermostat) Replace(change *Change, update *Thermostat) error {
+	return stat.update(change, "replaced configuration", func(candidate *Thermostat) error {
+		if !update.validUnits() {
+			return InvalidError(invalidUnits)
+		}
+		candidate.replaceWith(update)
+		return nil
+	})
+}
+
+// RollBack restores the configuration from snapshot, a copy of the configuration at an earlier revision.
+func (stat *Thermostat) RollBack(change *Change, snapshot *Thermostat) error {
+	return stat.update(change, fmt.Sprintf("rolled back to revision %d", snapshot.Revision), func(candidate *Thermostat) error {
+		candidate.replaceWith(snapshot)
+		return nil
+	})
+}
+
+func (stat *Thermostat) replaceWith(update *Thermostat) {
+	stat.DefaultMode = update.DefaultMode
+	stat.MaxErrors = update.MaxErrors
+	stat.PollInterval = update.PollInterval
+	stat.MinFan = update.MinFan
+	stat.MinOff = update.MinOff
+	stat.Schedule = update.Schedule
+	stat.Hold = update.Hold
+
+	previous := stat.UnitPreference
+	stat.UnitPreference = update.UnitPreference
+	stat.setTemperatures(update)
+	if previous != "" && previous != stat.UnitPreference {
+		stat.unitsChanged(previous)
+	}
+}
+
+// SetHold overrides the schedule with hold, or goes back to following the schedule if hold is nil.
+func (stat *Thermostat) SetHold(change *Change, hold *Hold) error {
+	action := "released hold"
+	if hold != nil {
+		action = "held mode " + hold.Mode
+		if !hold.Until.IsZero() {
+			action += " until " + hold.Until.Format(time.RFC3339)
+		}
+	}
+
+	return stat.update(change, action, func(candidate *Thermostat) error {
+		candidate.Hold = hold
+		return nil
+	})
+}
+
+// SetMode creates or replaces the mode called name.  A window without units is taken to be in UnitPreference.  It
+// reports whether the mode was created.
+func (stat *Thermostat) SetMode(change *Change, name string, window *Window) (created bool, err error) {
+	err = stat.update(change, "set mode "+name, func(candidate *Thermostat) error {
+		if window.Units() != "" && !window.Units().Valid() {
+			return InvalidError(invalidUnits)
+		}
+		_, exists := candidate.Modes[name]
+		created = !exists
+		candidate.Modes[name] = window.in(candidate.UnitPreference, candidate.UnitPreference)
+		return nil
+	})
+	return created, err
+}
+
+// DeleteMode removes the mode called name unless it is the default or referenced by the schedule.
+func (stat *Thermostat) DeleteMode(change *Change, name string) error {
+	return stat.update(change, "deleted mode "+name, func(candidate *Thermostat) error {
+		if _, ok := candidate.Modes[name]; !ok {
+			return ErrNotFound
+		}
+		if candidate.DefaultMode == name {
+			return ErrInUse
+		}
+		for _, spec := range candidate.Schedule {
+			if spec.ModeName == name {
+				return ErrInUse
+			}
+		}
+		delete(candidate.Modes, name)
+		return nil
+	})
+}
+
+// ScheduleEvent returns the schedule event with the given id.
+func (stat *Thermostat) ScheduleEvent(id string) (*ScheduleEvent, error) {
+	stat.mu.RLock()
+	defer stat.mu.RUnlock()
+
+	for _, spec := range stat.Schedule {
+		if spec.ID == id {
+			return spec.copy(), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// AddScheduleEvent appends spec to the schedule, assigning it an ID if it doesn't have one.
+func (stat *Thermostat) AddScheduleEvent(change *Change, spec *ScheduleEvent) error {
+	if spec.ID == "" {
+		spec.ID = util.NewID()
+	}
+
+	return stat.update(change, "added schedule entry "+spec.ID, func(candidate *Thermostat) error {
+		copied := spec.copy()
+		for _, existing := range candidate.Schedule {
+			if existing.ID == copied.ID {
+				return InvalidError("Schedule entry " + copied.ID + " already exists.")
+			}
+		}
+		candidate.Schedule = append(candidate.Schedule, copied)
+		return nil
+	})
+}
+
+// ReplaceScheduleEvent replaces the schedule event with the same ID as spec.
+func (stat *Thermostat) ReplaceScheduleEvent(change *Change, spec *ScheduleEvent) error {
+	return stat.update(change, "updated schedule entry "+spec.ID, func(candidate *Thermostat) error {
+		for i, existing := range candidate.Schedule {
+			if existing.ID == spec.ID {
+				candidate.Schedule[i] = spec.copy()
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+}
+
+// update applies apply to a copy of the configuration and swaps it in if the result is valid and change was based on
+// the current revision.  The change is recorded in the audit trail as action.
+func (stat *Thermostat) update(change *Change, action string, apply func(candidate *Thermostat) error) error {
+	defer stat.deliverChanges()
+
+	stat.mu.Lock()
+	defer stat.mu.Unlock()
+
+	if change.Revision != AnyRevision && change.Revision != stat.Revision {
+		return ErrRevisionMismatch
+	}
+
+	candidate := stat.copy()
+	candidate.Modes = make(Modes, len(stat.Modes))
+	for name, window := range stat.Modes {
+		candidate.Modes[name] = window
+	}
+	candidate.Schedule = append([]*ScheduleEvent(nil), stat.Schedule...)
+
+	if err := apply(candidate); err != nil {
+		return err
+	}
+	if msg := candidate.Validate(); msg != "" {
+		return InvalidError(msg)
+	}
+
+	stat.configure(candidate)
+	change.Revision = stat.Revision
+
+	log.Printf("%s %s (revision %d)", change.Author, action, stat.Revision)
+	entry := &AuditEntry{Revision: stat.Revision, Time: time.Now(), Author: change.Author, Action: action}
+	stat.audit = append(stat.audit, entry)
+	if len(stat.audit) > maxAuditEntries {
+		stat.audit = stat.audit[len(stat.audit)-maxAuditEntries:]
+	}
+
+	stat.publish(StreamConfig, entry)
+	if stat.onChange != nil {
+		stat.changesMu.Lock()
+		stat.changes = append(stat.changes, &pendingChange{listener: stat.onChange, entry: entry, snapshot: stat.copy()})
+		stat.changesMu.Unlock()
+	}
+	return nil
+}
+
+// pendingChange is an accepted change waiting to be reported to the change listener.
+type pendingChange struct {
+	listener func(*AuditEntry, *Thermostat)
+	entry    *AuditEntry
+	snapshot *Thermostat
+}
+
+// deliverChanges reports the queued changes to their listener in order.  If another goroutine is already delivering,
+// it reports them instead.  Must be called without mu held.
+func (stat *Thermostat) deliverChanges() {
+	stat.changesMu.Lock()
+	defer stat.changesMu.Unlock()
+	if stat.delivering {
+		return
+	}
+
+	stat.delivering = true
+	for len(stat.changes) > 0 {
+		next := stat.changes[0]
+		stat.changes = stat.changes[1:]
+
+		stat.changesMu.Unlock()
+		next.listener(next.entry, next.snapshot)
+		stat.changesMu.Lock()
+	}
+	stat.delivering = false
+}
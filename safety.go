@@ -0,0 +1,78 @@
+package thermostat
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/alittlebrighter/thermostat/controller"
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+// SafetyBreach describes a temperature outside of the Thermostat's Safety limits.
+type SafetyBreach struct {
+	// Direction is what the thermostat is doing about it, heating or cooling.
+	Direction controller.ThermoDirection `json:"direction"`
+	Limit     float64                    `json:"limit"`
+	// Temperature is the reading that breached the limit.
+	Temperature float64   `json:"temperature"`
+	Since       time.Time `json:"since"`
+}
+
+// safeWindow narrows window to the Safety limits so that whatever the schedule, a hold or a mode say the thermostat
+// heats or cools back to well within them.  A window entirely beyond a limit is moved within it as a whole, keeping
+// its width, rather than inverted, which would swap straight from heating to cooling.  Must be called with mu held.
+func (stat *Thermostat) safeWindow(window *Window) *Window {
+	if stat.Safety == nil || window == nil {
+		return window
+	}
+
+	safe := *window
+	width := window.High.Degrees - window.Low.Degrees
+	if safe.Low.Degrees < stat.Safety.Low.Degrees {
+		safe.Low.Degrees = stat.Safety.Low.Degrees
+		if safe.High.Degrees < safe.Low.Degrees {
+			safe.High.Degrees = math.Min(safe.Low.Degrees+width, stat.Safety.High.Degrees)
+		}
+	}
+	if safe.High.Degrees > stat.Safety.High.Degrees {
+		safe.High.Degrees = stat.Safety.High.Degrees
+		if safe.Low.Degrees > safe.High.Degrees {
+			safe.Low.Degrees = math.Max(safe.High.Degrees-width, stat.Safety.Low.Degrees)
+		}
+	}
+	return &safe
+}
+
+// checkSafety records whether temp is outside of the Safety limits and returns the direction needed to get back
+// within them, or None.  Breaches and their end are logged and published.  Must be called with mu held.
+func (stat *Thermostat) checkSafety(temp float64, now time.Time) controller.ThermoDirection {
+	var breach *SafetyBreach
+	switch {
+	case stat.Safety == nil:
+	case temp < stat.Safety.Low.Degrees:
+		breach = &SafetyBreach{Direction: controller.Heating, Limit: stat.Safety.Low.Degrees, Temperature: temp, Since: now}
+	case temp > stat.Safety.High.Degrees:
+		breach = &SafetyBreach{Direction: controller.Cooling, Limit: stat.Safety.High.Degrees, Temperature: temp, Since: now}
+	}
+
+	switch {
+	case breach == nil && stat.breach != nil:
+		log.Println("back within safety limits")
+		stat.breach = nil
+		stat.Events.Add(&util.EventLog{AmbientTemperature: stat.temperature(temp), Direction: stat.control.Direction(), Message: "back within safety limits"})
+		stat.publish(StreamSafety, (*SafetyBreach)(nil))
+	case breach != nil && (stat.breach == nil || stat.breach.Direction != breach.Direction):
+		message := fmt.Sprintf("%.1f° %s is beyond the safety limit of %.1f", temp, stat.UnitPreference, breach.Limit)
+		log.Println("SAFETY: " + message)
+		stat.breach = breach
+		stat.Events.Add(&util.EventLog{AmbientTemperature: stat.temperature(temp), Direction: stat.control.Direction(), Message: message})
+		stat.publish(StreamSafety, breach)
+	}
+
+	if stat.breach == nil {
+		return controller.None
+	}
+	return stat.breach.Direction
+}
@@ -0,0 +1,76 @@
+package thermostat
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+// SanityChecks reject readings that can't be trusted, e.g. a glitched sensor or a remote thermometer serving the
+// same cached value for hours.  A rejected reading counts as a failed reading: it is logged as an error and fed to
+// HandleError instead of driving the HVAC system.  Readings of calibrated thermometers are checked before they are
+// corrected.  Temperatures are in degrees of UnitPreference and zero values disable a check.
+type SanityChecks struct {
+	// Plausible is the range of temperatures a working sensor can report.
+	Plausible *Window `json:"plausible"`
+	// MaxRate is how many degrees per minute the temperature can change by since the last accepted reading.
+	MaxRate float64 `json:"maxRate"`
+	// StuckAfter is how long the exact same temperature can be reported before the sensor is considered stuck.
+	StuckAfter util.Duration `json:"stuckAfter"`
+}
+
+// sample is a temperature reading in degrees of UnitPreference and when it was taken.
+type sample struct {
+	temp float64
+	time time.Time
+}
+
+// convert returns reading in degrees of UnitPreference.  Must be called with mu held.
+func (stat *Thermostat) convert(reading util.Temperature) float64 {
+	return reading.In(stat.UnitPreference).Degrees
+}
+
+// temperature returns temp as a Temperature in UnitPreference.  Must be called with mu held.
+func (stat *Thermostat) temperature(temp float64) util.Temperature {
+	return util.Temperature{Degrees: temp, Units: stat.UnitPreference}
+}
+
+// checkReading returns an error if reading, taken at now, fails the sanity checks and otherwise remembers it as the
+// last accepted reading.  Must be called with mu held.
+func (stat *Thermostat) checkReading(reading util.Temperature, now time.Time) error {
+	if !reading.Valid() {
+		return fmt.Errorf("invalid reading of %s", reading)
+	}
+	temp := stat.convert(reading)
+	if checks := stat.Sanity; checks != nil {
+		if err := stat.sane(temp, now, checks); err != nil {
+			return err
+		}
+	}
+
+	stat.lastAccepted = &sample{temp: temp, time: now}
+	return nil
+}
+
+// sane applies checks to temp.  Must be called with mu held.
+func (stat *Thermostat) sane(temp float64, now time.Time, checks *SanityChecks) error {
+	if checks.Plausible != nil && (temp < checks.Plausible.Low.Degrees || temp > checks.Plausible.High.Degrees) {
+		return fmt.Errorf("implausible reading of %.1f° %s", temp, stat.UnitPreference)
+	}
+
+	if stat.lastValue == nil || stat.lastValue.temp != temp {
+		stat.lastValue = &sample{temp: temp, time: now}
+	} else if checks.StuckAfter > 0 && now.Sub(stat.lastValue.time) >= time.Duration(checks.StuckAfter) {
+		return fmt.Errorf("thermometer stuck at %.1f° %s since %s", temp, stat.UnitPreference, stat.lastValue.time.Format(time.Kitchen))
+	}
+
+	if checks.MaxRate > 0 && stat.lastAccepted != nil {
+		minutes := now.Sub(stat.lastAccepted.time).Minutes()
+		if change := math.Abs(temp - stat.lastAccepted.temp); minutes > 0 && change/minutes > checks.MaxRate {
+			return fmt.Errorf("reading of %.1f° %s changed too fast from %.1f", temp, stat.UnitPreference, stat.lastAccepted.temp)
+		}
+	}
+	return nil
+}
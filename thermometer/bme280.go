@@ -0,0 +1,229 @@
+package thermometer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alittlebrighter/embd"
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+// BME280 registers and values, see the BME280 datasheet.
+const (
+	bme280DefaultAddress = 0x76
+	bme280RegCalib       = 0x88
+	bme280RegH1          = 0xA1
+	bme280RegChipID      = 0xD0
+	bme280RegCalibH      = 0xE1
+	bme280RegCtrlHum     = 0xF2
+	bme280RegStatus      = 0xF3
+	bme280RegCtrlMeas    = 0xF4
+	bme280RegData        = 0xF7
+
+	bme280ChipID = 0x60
+	// bme280Forced measures temperature and pressure once, oversampled x1, and goes back to sleep.
+	bme280Forced = 0x25
+	// bme280Humidity oversamples humidity x1.
+	bme280Humidity  = 0x01
+	bme280Measuring = 0x08
+
+	// a forced measurement with every oversampling at x1 takes under 10ms
+	bme280Poll     = 2 * time.Millisecond
+	bme280MaxPolls = 25
+)
+
+// BME280 is a temperature, humidity and pressure sensor on an I2C bus.  Every reading takes a fresh measurement in
+// forced mode, leaving the sensor asleep in between.
+type BME280 struct {
+	bus     embd.I2CBus
+	address byte
+
+	mu    sync.Mutex
+	calib bme280Calibration
+}
+
+type bme280Calibration struct {
+	t1         uint16
+	t2, t3     int16
+	p1         uint16
+	p2, p3, p4 int16
+	p5, p6, p7 int16
+	p8, p9     int16
+	h1, h3     uint8
+	h2, h4, h5 int16
+	h6         int8
+}
+
+// bme280Measurement is a measurement compensated with the sensor's calibration.
+type bme280Measurement struct {
+	celsius, humidity, pressure float64
+}
+
+// NewBME280 connects to a BME280 on the configured bus, at 0x76 unless another address is set.
+func NewBME280(config *I2CConfig) (*BME280, error) {
+	address, err := config.address(bme280DefaultAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	meter, err := newBME280(config.bus(), address)
+	if err != nil {
+		closeBus()
+	}
+	return meter, err
+}
+
+func newBME280(bus embd.I2CBus, address byte) (*BME280, error) {
+	meter := &BME280{bus: bus, address: address}
+
+	id, err := bus.ReadByteFromReg(address, bme280RegChipID)
+	if err != nil {
+		return nil, err
+	}
+	if id != bme280ChipID {
+		return nil, fmt.Errorf("no BME280 at %#x, chip ID is %#x", address, id)
+	}
+
+	if err = meter.readCalibration(); err != nil {
+		return nil, err
+	}
+	return meter, nil
+}
+
+func (meter *BME280) readCalibration() error {
+	data := make([]byte, 26)
+	if err := meter.bus.ReadFromReg(meter.address, bme280RegCalib, data); err != nil {
+		return err
+	}
+	word := func(i int) uint16 { return uint16(data[i]) | uint16(data[i+1])<<8 }
+	c := &meter.calib
+	c.t1, c.t2, c.t3 = word(0), int16(word(2)), int16(word(4))
+	c.p1, c.p2, c.p3 = word(6), int16(word(8)), int16(word(10))
+	c.p4, c.p5, c.p6 = int16(word(12)), int16(word(14)), int16(word(16))
+	c.p7, c.p8, c.p9 = int16(word(18)), int16(word(20)), int16(word(22))
+
+	h1, err := meter.bus.ReadByteFromReg(meter.address, bme280RegH1)
+	if err != nil {
+		return err
+	}
+	h := make([]byte, 7)
+	if err := meter.bus.ReadFromReg(meter.address, bme280RegCalibH, h); err != nil {
+		return err
+	}
+	c.h1 = h1
+	c.h2 = int16(uint16(h[0]) | uint16(h[1])<<8)
+	c.h3 = h[2]
+	c.h4 = int16(int8(h[3]))<<4 | int16(h[4]&0x0F)
+	c.h5 = int16(int8(h[5]))<<4 | int16(h[4]>>4)
+	c.h6 = int8(h[6])
+	return nil
+}
+
+// measure takes a forced measurement.
+func (meter *BME280) measure() (*bme280Measurement, error) {
+	meter.mu.Lock()
+	defer meter.mu.Unlock()
+
+	// ctrl_hum only takes effect with the next write to ctrl_meas
+	if err := meter.bus.WriteByteToReg(meter.address, bme280RegCtrlHum, bme280Humidity); err != nil {
+		return nil, err
+	}
+	if err := meter.bus.WriteByteToReg(meter.address, bme280RegCtrlMeas, bme280Forced); err != nil {
+		return nil, err
+	}
+
+	for polls := 0; ; polls++ {
+		status, err := meter.bus.ReadByteFromReg(meter.address, bme280RegStatus)
+		if err != nil {
+			return nil, err
+		}
+		if status&bme280Measuring == 0 {
+			break
+		}
+		if polls >= bme280MaxPolls {
+			return nil, errors.New("BME280 measurement timed out")
+		}
+		time.Sleep(bme280Poll)
+	}
+
+	data := make([]byte, 8)
+	if err := meter.bus.ReadFromReg(meter.address, bme280RegData, data); err != nil {
+		return nil, err
+	}
+	rawP := int32(data[0])<<12 | int32(data[1])<<4 | int32(data[2])>>4
+	rawT := int32(data[3])<<12 | int32(data[4])<<4 | int32(data[5])>>4
+	rawH := int32(data[6])<<8 | int32(data[7])
+	return meter.calib.compensate(rawT, rawP, rawH), nil
+}
+
+// compensate converts raw readings with the floating point formulas from the datasheet.
+func (c *bme280Calibration) compensate(rawT, rawP, rawH int32) *bme280Measurement {
+	adcT, adcP, adcH := float64(rawT), float64(rawP), float64(rawH)
+
+	var1 := (adcT/16384 - float64(c.t1)/1024) * float64(c.t2)
+	var2 := (adcT/131072 - float64(c.t1)/8192) * (adcT/131072 - float64(c.t1)/8192) * float64(c.t3)
+	tFine := var1 + var2
+	m := &bme280Measurement{celsius: tFine / 5120}
+
+	var1 = tFine/2 - 64000
+	var2 = var1 * var1 * float64(c.p6) / 32768
+	var2 += var1 * float64(c.p5) * 2
+	var2 = var2/4 + float64(c.p4)*65536
+	var1 = (float64(c.p3)*var1*var1/524288 + float64(c.p2)*var1) / 524288
+	var1 = (1 + var1/32768) * float64(c.p1)
+	if var1 != 0 {
+		p := 1048576 - adcP
+		p = (p - var2/4096) * 6250 / var1
+		var1 = float64(c.p9) * p * p / 2147483648
+		var2 = p * float64(c.p8) / 32768
+		m.pressure = p + (var1+var2+float64(c.p7))/16
+	}
+
+	h := tFine - 76800
+	h = (adcH - (float64(c.h4)*64 + float64(c.h5)/16384*h)) *
+		(float64(c.h2) / 65536 * (1 + float64(c.h6)/67108864*h*(1+float64(c.h3)/67108864*h)))
+	h *= 1 - float64(c.h1)*h/524288
+	switch {
+	case h > 100:
+		h = 100
+	case h < 0:
+		h = 0
+	}
+	m.humidity = h
+
+	return m
+}
+
+// ReadTemperature measures the current temperature.
+func (meter *BME280) ReadTemperature() (util.Temperature, error) {
+	m, err := meter.measure()
+	if err != nil {
+		return util.Temperature{}, err
+	}
+	return util.Temperature{Degrees: m.celsius, Units: util.Celsius}, nil
+}
+
+// ReadHumidity measures the current relative humidity in percent.
+func (meter *BME280) ReadHumidity() (float64, error) {
+	m, err := meter.measure()
+	if err != nil {
+		return 0, err
+	}
+	return m.humidity, nil
+}
+
+// ReadPressure measures the current air pressure in Pa.
+func (meter *BME280) ReadPressure() (float64, error) {
+	m, err := meter.measure()
+	if err != nil {
+		return 0, err
+	}
+	return m.pressure, nil
+}
+
+// Shutdown releases the I2C bus, the BME280 sleeps between measurements anyway.
+func (meter *BME280) Shutdown() {
+	closeBus()
+}
@@ -0,0 +1,51 @@
+package thermometer
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestBME280(t *testing.T) {
+	// calibration and raw values from the BME280 datasheet's example
+	calib := make([]byte, 26)
+	for i, word := range []int{27504, 26435, -1000, 36477, -10685, 3024, 2855, 140, -7, 15500, -14600, 6000} {
+		binary.LittleEndian.PutUint16(calib[2*i:], uint16(word))
+	}
+	bus := newFakeBus(0x77, 1, map[byte][]byte{
+		bme280RegCalib:  calib,
+		bme280RegH1:     {75},
+		bme280RegCalibH: {0x6A, 0x01, 0x00, 0x13, 0x2B, 0x03, 0x1E},
+		bme280RegChipID: {bme280ChipID},
+		bme280RegData:   {0x65, 0x5A, 0xC0, 0x7E, 0xED, 0x00, 0x6F, 0x2A},
+	})
+
+	if _, err := newBME280(bus, 0x76); err != errNoDevice {
+		t.Errorf("Expected no device at the wrong address, got %v", err)
+	}
+
+	meter, err := newBME280(bus, 0x77)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if temp, err := meter.ReadTemperature(); err != nil || math.Abs(temp.Degrees-25.08) > 0.01 {
+		t.Errorf("Expected 25.08°C, got %v, %v", temp, err)
+	}
+	if pressure, err := meter.ReadPressure(); err != nil || math.Abs(pressure-100653.27) > 0.5 {
+		t.Errorf("Expected 100653.27 Pa, got %v, %v", pressure, err)
+	}
+	if humidity, err := meter.ReadHumidity(); err != nil || humidity <= 0 || humidity >= 100 {
+		t.Errorf("Expected a relative humidity, got %v, %v", humidity, err)
+	}
+
+	// ctrl_hum must be written before ctrl_meas to take effect
+	if expected := []string{"0xf2:01", "0xf4:25"}; !reflect.DeepEqual(bus.writes[:2], expected) {
+		t.Errorf("Expected a forced measurement %v, got %v", expected, bus.writes)
+	}
+
+	bus.set(0x77, bme280RegChipID, 0x58)
+	if _, err := newBME280(bus, 0x77); err == nil {
+		t.Error("Expected a BMP280 to be rejected")
+	}
+}
@@ -0,0 +1,198 @@
+package thermometer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+// CalibrationConfig corrects a sensor's readings, in the units the sensor reports.
+type CalibrationConfig struct {
+	// Offset is added to every reading, e.g. -1.5 for a sensor that the Pi's CPU warms up by 1.5°.
+	Offset float64 `json:"offset"`
+	// Points, if set, are two raw readings and what a reference thermometer read at the same time.  Readings are
+	// corrected along the line through them, before Offset is added.
+	Points []CalibrationPoint `json:"points"`
+}
+
+// CalibrationPoint pairs a raw reading with the actual temperature.
+type CalibrationPoint struct {
+	Raw    float64 `json:"raw"`
+	Actual float64 `json:"actual"`
+}
+
+// SmoothingConfig filters a sensor's readings.
+type SmoothingConfig struct {
+	// Filter is "average" for a moving average, "median" or "exponential".
+	Filter string `json:"filter"`
+	// Samples is how many readings the average and median filters take, 5 by default.
+	Samples int `json:"samples"`
+	// Alpha weighs the latest reading in exponential smoothing, from 0 to 1, 0.3 by default.
+	Alpha float64 `json:"alpha"`
+}
+
+// Smoothing filters.
+const (
+	FilterAverage     = "average"
+	FilterMedian      = "median"
+	FilterExponential = "exponential"
+)
+
+const (
+	defaultSamples = 5
+	defaultAlpha   = 0.3
+)
+
+// errNotHygrometer is returned for the humidity of a thermometer that doesn't measure it.
+var errNotHygrometer = errors.New("thermometer does not measure humidity")
+
+// Validate checks that the calibration has either no points or two different ones.
+func (config *CalibrationConfig) Validate() error {
+	switch {
+	case len(config.Points) == 0:
+	case len(config.Points) != 2:
+		return fmt.Errorf("calibration takes 2 points, not %d", len(config.Points))
+	case config.Points[0].Raw == config.Points[1].Raw:
+		return errors.New("calibration points must be different raw readings")
+	}
+	return nil
+}
+
+// Validate checks that the filter is known and its parameters are in range.
+func (config *SmoothingConfig) Validate() error {
+	switch config.Filter {
+	case FilterAverage, FilterMedian, FilterExponential:
+	default:
+		return fmt.Errorf("unknown smoothing filter %q", config.Filter)
+	}
+	if config.Samples < 0 {
+		return errors.New("smoothing samples must not be negative")
+	}
+	if config.Alpha < 0 || config.Alpha > 1 {
+		return errors.New("smoothing alpha must be between 0 and 1")
+	}
+	return nil
+}
+
+// Calibrated is a Thermometer that corrects and smooths the readings of another one.
+type Calibrated struct {
+	meter       Thermometer
+	calibration *CalibrationConfig
+	smoothing   *SmoothingConfig
+
+	mu       sync.Mutex
+	units    util.TemperatureUnits
+	history  []float64
+	smoothed *float64
+}
+
+// NewCalibrated wraps meter, either config may be nil.
+func NewCalibrated(meter Thermometer, calibration *CalibrationConfig, smoothing *SmoothingConfig) (*Calibrated, error) {
+	if calibration != nil {
+		if err := calibration.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if smoothing != nil {
+		if err := smoothing.Validate(); err != nil {
+			return nil, err
+		}
+		config := *smoothing
+		if config.Samples == 0 {
+			config.Samples = defaultSamples
+		}
+		if config.Alpha == 0 {
+			config.Alpha = defaultAlpha
+		}
+		smoothing = &config
+	}
+	return &Calibrated{meter: meter, calibration: calibration, smoothing: smoothing}, nil
+}
+
+// ReadTemperature reads the wrapped thermometer and returns the calibrated, smoothed value.
+func (meter *Calibrated) ReadTemperature() (util.Temperature, error) {
+	raw, err := meter.ReadRaw()
+	if err != nil {
+		return raw, err
+	}
+	return meter.Correct(raw), nil
+}
+
+// ReadRaw reads the wrapped thermometer without correcting the reading.
+func (meter *Calibrated) ReadRaw() (util.Temperature, error) {
+	return meter.meter.ReadTemperature()
+}
+
+// Correct returns raw calibrated and smoothed, adding it to the smoothing history.
+func (meter *Calibrated) Correct(raw util.Temperature) util.Temperature {
+	meter.mu.Lock()
+	defer meter.mu.Unlock()
+
+	if raw.Units != meter.units {
+		meter.units, meter.history, meter.smoothed = raw.Units, nil, nil
+	}
+	return util.Temperature{Degrees: meter.smooth(meter.calibrate(raw.Degrees)), Units: raw.Units}
+}
+
+func (meter *Calibrated) calibrate(temp float64) float64 {
+	if meter.calibration == nil {
+		return temp
+	}
+	if points := meter.calibration.Points; len(points) == 2 {
+		gain := (points[1].Actual - points[0].Actual) / (points[1].Raw - points[0].Raw)
+		temp = points[0].Actual + (temp-points[0].Raw)*gain
+	}
+	return temp + meter.calibration.Offset
+}
+
+// smooth adds temp to the history and returns the filtered value.  Must be called with mu held.
+func (meter *Calibrated) smooth(temp float64) float64 {
+	if meter.smoothing == nil {
+		return temp
+	}
+
+	if meter.smoothing.Filter == FilterExponential {
+		if meter.smoothed != nil {
+			temp = meter.smoothing.Alpha*temp + (1-meter.smoothing.Alpha)*(*meter.smoothed)
+		}
+		meter.smoothed = &temp
+		return temp
+	}
+
+	meter.history = append(meter.history, temp)
+	if len(meter.history) > meter.smoothing.Samples {
+		meter.history = meter.history[len(meter.history)-meter.smoothing.Samples:]
+	}
+
+	if meter.smoothing.Filter == FilterMedian {
+		sorted := append([]float64(nil), meter.history...)
+		sort.Float64s(sorted)
+		middle := len(sorted) / 2
+		if len(sorted)%2 == 1 {
+			return sorted[middle]
+		}
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+
+	var sum float64
+	for _, t := range meter.history {
+		sum += t
+	}
+	return sum / float64(len(meter.history))
+}
+
+// ReadHumidity passes the humidity of the wrapped thermometer through, uncorrected.
+func (meter *Calibrated) ReadHumidity() (float64, error) {
+	if hygrometer, ok := meter.meter.(Hygrometer); ok {
+		return hygrometer.ReadHumidity()
+	}
+	return 0, errNotHygrometer
+}
+
+// Shutdown shuts the wrapped thermometer down.
+func (meter *Calibrated) Shutdown() {
+	meter.meter.Shutdown()
+}
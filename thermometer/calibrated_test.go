@@ -0,0 +1,62 @@
+package thermometer
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestCalibrated(t *testing.T) {
+	sensor := &scriptedThermometer{}
+	read := func(meter *Calibrated, raw float64) float64 {
+		sensor.temp = raw
+		temp, err := meter.ReadTemperature()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return temp.Degrees
+	}
+
+	meter, _ := NewCalibrated(sensor, &CalibrationConfig{Offset: -1.5}, nil)
+	if temp := read(meter, 22.5); temp != 21 {
+		t.Errorf("Expected the offset applied, got %v", temp)
+	}
+	if raw, err := meter.ReadRaw(); err != nil || raw.Degrees != 22.5 {
+		t.Errorf("Expected the raw reading, got %v, %v", raw, err)
+	}
+
+	// a sensor reading 1° high at freezing and 3° high at 100°C
+	meter, _ = NewCalibrated(sensor, &CalibrationConfig{Points: []CalibrationPoint{{Raw: 1, Actual: 0}, {Raw: 103, Actual: 100}}}, nil)
+	if temp := read(meter, 52); math.Abs(temp-50) > 1e-9 {
+		t.Errorf("Expected the two point correction applied, got %v", temp)
+	}
+
+	for filter, expected := range map[string][]float64{
+		FilterAverage:     {20, 20.5, 21, 21.75, 23},
+		FilterMedian:      {20, 20.5, 21, 21.5, 23},
+		FilterExponential: {20, 20.5, 21.25, 22.625, 23.8125},
+	} {
+		meter, _ = NewCalibrated(sensor, nil, &SmoothingConfig{Filter: filter, Samples: 4, Alpha: 0.5})
+		for i, raw := range []float64{20, 21, 22, 24, 25} {
+			if temp := read(meter, raw); temp != expected[i] {
+				t.Errorf("Expected %s reading %d to be %v, got %v", filter, i, expected[i], temp)
+			}
+		}
+	}
+
+	sensor.err = errors.New("bus error")
+	if _, err := meter.ReadTemperature(); err != sensor.err {
+		t.Errorf("Expected errors passed through, got %v", err)
+	}
+
+	for _, config := range []interface{ Validate() error }{
+		&CalibrationConfig{Points: []CalibrationPoint{{Raw: 1, Actual: 0}}},
+		&CalibrationConfig{Points: []CalibrationPoint{{Raw: 1, Actual: 0}, {Raw: 1, Actual: 2}}},
+		&SmoothingConfig{Filter: "kalman"},
+		&SmoothingConfig{Filter: FilterExponential, Alpha: 2},
+	} {
+		if config.Validate() == nil {
+			t.Errorf("Expected %+v to be invalid", config)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package thermometer
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+// DS18B20Config selects a DS18B20 on the 1-Wire bus.
+type DS18B20Config struct {
+	// Device is the sensor's ID, e.g. 28-0316a2793dff.  The first DS18B20 found is used if not set.
+	Device string `json:"device"`
+	// Dir is where the kernel's w1_therm driver lists the devices, /sys/bus/w1/devices by default.
+	Dir string `json:"dir"`
+}
+
+const (
+	defaultW1Dir = "/sys/bus/w1/devices"
+	// ds18b20Family prefixes the IDs of DS18B20 sensors.
+	ds18b20Family = "28-"
+	// ds18b20PowerOn is the value a DS18B20 reports, in millidegrees, before it converted a temperature.
+	ds18b20PowerOn = 85000
+)
+
+// DS18B20 reads a DS18B20 temperature sensor through the Linux 1-Wire sysfs interface.
+type DS18B20 struct {
+	file string
+}
+
+// NewDS18B20 finds the configured DS18B20.
+func NewDS18B20(config *DS18B20Config) (*DS18B20, error) {
+	dir := config.Dir
+	if dir == "" {
+		dir = defaultW1Dir
+	}
+
+	device := config.Device
+	if device == "" {
+		devices, err := filepath.Glob(filepath.Join(dir, ds18b20Family+"*"))
+		if err != nil {
+			return nil, err
+		}
+		if len(devices) == 0 {
+			return nil, errors.New("no DS18B20 found in " + dir)
+		}
+		sort.Strings(devices)
+		device = filepath.Base(devices[0])
+	}
+
+	meter := &DS18B20{file: filepath.Join(dir, device, "w1_slave")}
+	if _, err := ioutil.ReadFile(meter.file); err != nil {
+		return nil, err
+	}
+	return meter, nil
+}
+
+// ReadTemperature reads the current temperature from the DS18B20, which takes up to 750ms.
+func (meter *DS18B20) ReadTemperature() (util.Temperature, error) {
+	data, err := ioutil.ReadFile(meter.file)
+	if err != nil {
+		return util.Temperature{}, err
+	}
+	temp, err := parseW1Slave(string(data))
+	return util.Temperature{Degrees: temp, Units: util.Celsius}, err
+}
+
+// parseW1Slave parses w1_slave output like:
+//
+//	72 01 4b 46 7f ff 0e 10 57 : crc=57 YES
+//	72 01 4b 46 7f ff 0e 10 57 t=23125
+func parseW1Slave(data string) (float64, error) {
+	lines := strings.Split(strings.TrimSpace(data), "\n")
+	if len(lines) != 2 {
+		return 0, fmt.Errorf("unexpected DS18B20 output %q", data)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(lines[0]), "YES") {
+		return 0, errors.New("DS18B20 reading failed its CRC check")
+	}
+
+	i := strings.LastIndex(lines[1], "t=")
+	if i < 0 {
+		return 0, fmt.Errorf("unexpected DS18B20 output %q", data)
+	}
+	milli, err := strconv.Atoi(strings.TrimSpace(lines[1][i+2:]))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected DS18B20 temperature: %s", err.Error())
+	}
+	if milli == ds18b20PowerOn {
+		return 0, errors.New("DS18B20 has not converted a temperature yet")
+	}
+	return float64(milli) / 1000, nil
+}
+
+// Shutdown exists for the DS18B20 purely to satisfy the Thermometer interface.
+func (meter *DS18B20) Shutdown() {}
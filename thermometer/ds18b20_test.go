@@ -0,0 +1,55 @@
+package thermometer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDS18B20(t *testing.T) {
+	dir := t.TempDir()
+	write := func(device, data string) {
+		if err := os.MkdirAll(filepath.Join(dir, device), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, device, "w1_slave"), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := NewDS18B20(&DS18B20Config{Dir: dir}); err == nil {
+		t.Error("Expected an error without any sensor")
+	}
+
+	write("w1_bus_master1", "")
+	write("28-0316a2793dff", "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=23125\n")
+	write("28-0416a2793dff", "ff fe 4b 46 7f ff 0e 10 57 : crc=57 YES\nff fe 4b 46 7f ff 0e 10 57 t=-125\n")
+
+	meter, err := NewDS18B20(&DS18B20Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if temp, err := meter.ReadTemperature(); err != nil || temp.Degrees != 23.125 {
+		t.Errorf("Expected the first sensor's 23.125, got %v, %v", temp, err)
+	}
+
+	meter, err = NewDS18B20(&DS18B20Config{Dir: dir, Device: "28-0416a2793dff"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if temp, err := meter.ReadTemperature(); err != nil || temp.Degrees != -0.125 {
+		t.Errorf("Expected the configured sensor's -0.125, got %v, %v", temp, err)
+	}
+
+	for name, data := range map[string]string{
+		"bad CRC":  "72 01 4b 46 7f ff 0e 10 57 : crc=58 NO\n72 01 4b 46 7f ff 0e 10 57 t=23125\n",
+		"power on": "50 05 4b 46 7f ff 0c 10 1c : crc=1c YES\n50 05 4b 46 7f ff 0c 10 1c t=85000\n",
+		"garbled":  "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n",
+	} {
+		write("28-0416a2793dff", data)
+		if _, err := meter.ReadTemperature(); err == nil {
+			t.Errorf("Expected an error for a %s reading", name)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package thermometer
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/alittlebrighter/embd"
+)
+
+// I2CConfig locates a sensor on an I2C bus.
+type I2CConfig struct {
+	// Bus is the number of the I2C bus, 1 by default as on the Raspberry Pi.
+	Bus int `json:"bus"`
+	// Address is the sensor's address on the bus, the sensor's default address if not set.
+	Address int `json:"address"`
+}
+
+const defaultI2CBus = 1
+
+// sensorsOpen counts the sensors using an I2C bus, embd only closes all of them at once.
+var (
+	sensorsMu   sync.Mutex
+	sensorsOpen int
+)
+
+// bus opens the configured I2C bus for a sensor, call closeBus when the sensor shuts down.
+func (config *I2CConfig) bus() embd.I2CBus {
+	sensorsMu.Lock()
+	defer sensorsMu.Unlock()
+	sensorsOpen++
+	return embd.NewI2CBus(byte(config.busNumber()))
+}
+
+// closeBus closes the I2C buses once the last sensor using them shut down, so that several sensors can share a bus.
+func closeBus() {
+	sensorsMu.Lock()
+	defer sensorsMu.Unlock()
+	if sensorsOpen == 0 {
+		return
+	}
+	if sensorsOpen--; sensorsOpen == 0 {
+		if err := embd.CloseI2C(); err != nil {
+			log.Println("Error closing I2C bus: " + err.Error())
+		}
+	}
+}
+
+func (config *I2CConfig) busNumber() int {
+	if config.Bus <= 0 {
+		return defaultI2CBus
+	}
+	return config.Bus
+}
+
+// address returns the configured address, or fallback if none is set.
+func (config *I2CConfig) address(fallback byte) (byte, error) {
+	if config.Address == 0 {
+		return fallback, nil
+	}
+	if config.Address < 0x03 || config.Address > 0x77 {
+		return 0, fmt.Errorf("invalid I2C address %#x", config.Address)
+	}
+	return byte(config.Address), nil
+}
+
+// i2cReader reads several bytes from a device in a single transaction without first writing a register address.
+// embd's I2CBus can't, so sensors with 16 bit commands read through a devI2C.
+type i2cReader interface {
+	ReadBytes(addr byte, value []byte) error
+}
+
+// i2cSlave is the ioctl request that addresses a device on an I2C bus.
+const i2cSlave = 0x0703
+
+// devI2C reads from the Linux I2C device of a bus.
+type devI2C struct {
+	path string
+}
+
+func newDevI2C(bus int) *devI2C {
+	return &devI2C{path: fmt.Sprintf("/dev/i2c-%d", bus)}
+}
+
+func (dev *devI2C) ReadBytes(addr byte, value []byte) error {
+	file, err := os.OpenFile(dev.path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), i2cSlave, uintptr(addr)); errno != 0 {
+		return errno
+	}
+	n, err := file.Read(value)
+	if err != nil {
+		return err
+	}
+	if n != len(value) {
+		return fmt.Errorf("short read from I2C device %#x: %d of %d bytes", addr, n, len(value))
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package thermometer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/alittlebrighter/embd"
+)
+
+// fakeBus is an I2C bus with a register map per device address, it records the register writes.  The methods that
+// aren't faked panic.
+type fakeBus struct {
+	embd.I2CBus
+
+	regs map[byte][]byte
+	// width is how many bytes every register holds, reads and writes run on into the following registers.
+	width  int
+	writes []string
+	// raw is what ReadBytes returns.
+	raw []byte
+}
+
+var errNoDevice = errors.New("no device at address")
+
+func newFakeBus(addr byte, width int, regs map[byte][]byte) *fakeBus {
+	bus := &fakeBus{regs: map[byte][]byte{addr: make([]byte, 256*width)}, width: width}
+	for reg, values := range regs {
+		bus.set(addr, reg, values...)
+	}
+	return bus
+}
+
+func (bus *fakeBus) set(addr, reg byte, values ...byte) {
+	copy(bus.regs[addr][int(reg)*bus.width:], values)
+}
+
+func (bus *fakeBus) device(addr byte) ([]byte, error) {
+	regs, ok := bus.regs[addr]
+	if !ok {
+		return nil, errNoDevice
+	}
+	return regs, nil
+}
+
+func (bus *fakeBus) ReadFromReg(addr, reg byte, value []byte) error {
+	regs, err := bus.device(addr)
+	if err != nil {
+		return err
+	}
+	copy(value, regs[int(reg)*bus.width:])
+	return nil
+}
+
+func (bus *fakeBus) ReadByteFromReg(addr, reg byte) (byte, error) {
+	regs, err := bus.device(addr)
+	if err != nil {
+		return 0, err
+	}
+	return regs[int(reg)*bus.width], nil
+}
+
+func (bus *fakeBus) ReadWordFromReg(addr, reg byte) (uint16, error) {
+	regs, err := bus.device(addr)
+	if err != nil {
+		return 0, err
+	}
+	i := int(reg) * bus.width
+	return uint16(regs[i])<<8 | uint16(regs[i+1]), nil
+}
+
+func (bus *fakeBus) WriteToReg(addr, reg byte, value []byte) error {
+	regs, err := bus.device(addr)
+	if err != nil {
+		return err
+	}
+	bus.writes = append(bus.writes, fmt.Sprintf("%#x:% x", reg, value))
+	copy(regs[int(reg)*bus.width:], value)
+	return nil
+}
+
+func (bus *fakeBus) WriteByteToReg(addr, reg, value byte) error {
+	return bus.WriteToReg(addr, reg, []byte{value})
+}
+
+func (bus *fakeBus) WriteWordToReg(addr, reg byte, value uint16) error {
+	return bus.WriteToReg(addr, reg, []byte{byte(value >> 8), byte(value)})
+}
+
+func (bus *fakeBus) Close() error {
+	return nil
+}
+
+func (bus *fakeBus) ReadBytes(addr byte, value []byte) error {
+	if _, err := bus.device(addr); err != nil {
+		return err
+	}
+	copy(value, bus.raw)
+	return nil
+}
@@ -1,43 +1,252 @@
 package thermometer
 
 import (
+	"fmt"
+	"log"
+	"math"
+
 	"github.com/alittlebrighter/embd"
 	_ "github.com/alittlebrighter/embd/host/rpi"
-	"github.com/alittlebrighter/embd/sensor/mcp9808"
 	"github.com/alittlebrighter/thermostat/util"
 )
 
-// MCP9808 is a simple wrapper of an MCP9808 temperature sensor.
+// MCP9808 registers and values, see the MCP9808 datasheet.
+const (
+	mcp9808DefaultAddress = 0x18
+	// the address pins select one of 8 addresses
+	mcp9808MaxAddress = 0x1F
+
+	mcp9808RegConfig     = 0x01
+	mcp9808RegUpper      = 0x02
+	mcp9808RegLower      = 0x03
+	mcp9808RegCritical   = 0x04
+	mcp9808RegAmbient    = 0x05
+	mcp9808RegManufID    = 0x06
+	mcp9808RegDeviceID   = 0x07
+	mcp9808RegResolution = 0x08
+
+	mcp9808ManufID  = 0x0054
+	mcp9808DeviceID = 0x04
+
+	mcp9808AlertPolarity = 1 << 1
+	mcp9808AlertSelect   = 1 << 2
+	mcp9808AlertControl  = 1 << 3
+	mcp9808WindowLock    = 1 << 6
+	mcp9808CriticalLock  = 1 << 7
+	mcp9808Shutdown      = 1 << 8
+
+	mcp9808AboveCritical = 1 << 15
+	mcp9808AboveUpper    = 1 << 14
+	mcp9808BelowLower    = 1 << 13
+)
+
+// MCP9808Config configures an MCP9808 beyond where it is on the bus.
+type MCP9808Config struct {
+	// Resolution is the step in °C the temperature is measured in: 0.5, 0.25, 0.125 or 0.0625, the default.  Finer
+	// resolutions take longer to convert, up to 250ms.
+	Resolution float64 `json:"resolution"`
+	// Hysteresis in °C keeps the alert limits from chattering as the temperature falls back below them: 0, the
+	// default, 1.5, 3 or 6.
+	Hysteresis float64 `json:"hysteresis"`
+	// Alert drives the MCP9808's alert output, which can be wired to cut the HVAC system off independently of the
+	// thermostat.
+	Alert *MCP9808Alert `json:"alert"`
+}
+
+// MCP9808Alert configures the alert output in comparator mode.  The limits are rounded to 0.25°C.
+type MCP9808Alert struct {
+	// Critical asserts the alert above this temperature in °C.
+	Critical float64 `json:"critical"`
+	// Lower and Upper also assert the alert outside of this window, in °C, unless CriticalOnly is set.
+	Lower        float64 `json:"lower"`
+	Upper        float64 `json:"upper"`
+	CriticalOnly bool    `json:"criticalOnly"`
+	// ActiveHigh drives the alert output high instead of pulling it low.
+	ActiveHigh bool `json:"activeHigh"`
+	// Lock keeps the limits from being changed until the MCP9808 is power cycled.
+	Lock bool `json:"lock"`
+}
+
+// MCP9808Alerts reports which alert limits the temperature is beyond.
+type MCP9808Alerts struct {
+	AboveCritical, AboveUpper, BelowLower bool
+}
+
+var (
+	mcp9808Resolutions = map[float64]byte{0.5: 0, 0.25: 1, 0.125: 2, 0.0625: 3}
+	mcp9808Hysteresis  = map[float64]uint16{0: 0, 1.5: 1 << 9, 3: 2 << 9, 6: 3 << 9}
+)
+
+// Validate checks that the configuration can be programmed into an MCP9808.
+func (config *MCP9808Config) Validate() error {
+	if _, ok := mcp9808Resolutions[config.Resolution]; config.Resolution != 0 && !ok {
+		return fmt.Errorf("MCP9808 resolution must be 0.5, 0.25, 0.125 or 0.0625, not %v", config.Resolution)
+	}
+	if _, ok := mcp9808Hysteresis[config.Hysteresis]; !ok {
+		return fmt.Errorf("MCP9808 hysteresis must be 0, 1.5, 3 or 6, not %v", config.Hysteresis)
+	}
+	if alert := config.Alert; alert != nil {
+		for _, limit := range []float64{alert.Critical, alert.Lower, alert.Upper} {
+			if limit < -40 || limit > 125 {
+				return fmt.Errorf("MCP9808 alert limit %v is outside of the sensor's range", limit)
+			}
+		}
+		if !alert.CriticalOnly && alert.Lower >= alert.Upper {
+			return fmt.Errorf("MCP9808 alert lower limit %v must be below the upper one", alert.Lower)
+		}
+	}
+	return nil
+}
+
+// MCP9808 is an MCP9808 temperature sensor on an I2C bus.  Up to 8 can share a bus at different addresses.
 type MCP9808 struct {
-	sensor *mcp9808.MCP9808
+	bus     embd.I2CBus
+	address byte
 }
 
-// NewMCP9808 is the constructor for the MCP9808 wrapper.
-func NewMCP9808() (*MCP9808, error) {
-	meter := new(MCP9808)
+// NewMCP9808 connects to an MCP9808 on the configured bus, at 0x18 unless another address is set, and programs it.
+func NewMCP9808(i2c *I2CConfig, config *MCP9808Config) (*MCP9808, error) {
+	address, err := i2c.address(mcp9808DefaultAddress)
+	if err != nil {
+		return nil, err
+	}
+	if address < mcp9808DefaultAddress || address > mcp9808MaxAddress {
+		return nil, fmt.Errorf("an MCP9808 can't be at address %#x", address)
+	}
+	if err = config.Validate(); err != nil {
+		return nil, err
+	}
 
-	var err error
-	bus := embd.NewI2CBus(1)
-	meter.sensor, err = mcp9808.New(bus)
+	meter, err := newMCP9808(i2c.bus(), address, config)
+	if err != nil {
+		closeBus()
+	}
+	return meter, err
+}
+
+func newMCP9808(bus embd.I2CBus, address byte, config *MCP9808Config) (*MCP9808, error) {
+	meter := &MCP9808{bus: bus, address: address}
+
+	manufacturer, err := bus.ReadWordFromReg(address, mcp9808RegManufID)
+	if err != nil {
+		return nil, err
+	}
+	device, err := bus.ReadWordFromReg(address, mcp9808RegDeviceID)
 	if err != nil {
 		return nil, err
 	}
+	if manufacturer != mcp9808ManufID || device>>8 != mcp9808DeviceID {
+		return nil, fmt.Errorf("no MCP9808 at %#x, IDs are %#x and %#x", address, manufacturer, device)
+	}
 
-	meter.sensor.SetShutdownMode(false)
-	meter.sensor.SetTempResolution(mcp9808.SixteenthC)
-	meter.sensor.SetTempHysteresis(mcp9808.Zero)
+	resolution, ok := mcp9808Resolutions[config.Resolution]
+	if !ok {
+		resolution = mcp9808Resolutions[0.0625]
+	}
+	if err = bus.WriteByteToReg(address, mcp9808RegResolution, resolution); err != nil {
+		return nil, err
+	}
 
+	if err = meter.configure(config); err != nil {
+		return nil, err
+	}
 	return meter, nil
 }
 
-// ReadTemperature reads the current ambient temperature from an MCP9808 unit.
-func (meter *MCP9808) ReadTemperature() (float64, util.TemperatureUnits, error) {
-	tempReading, err := meter.sensor.AmbientTemp()
-	return tempReading.CelsiusDeg, util.Celsius, err
+// configure programs hysteresis and the alert output and wakes the sensor up.
+func (meter *MCP9808) configure(config *MCP9808Config) error {
+	current, err := meter.bus.ReadWordFromReg(meter.address, mcp9808RegConfig)
+	if err != nil {
+		return err
+	}
+	if current&(mcp9808WindowLock|mcp9808CriticalLock) != 0 {
+		// locked registers ignore writes until the sensor is power cycled
+		log.Printf("MCP9808 at %#x is locked, keeping its alert configuration", meter.address)
+		return meter.bus.WriteWordToReg(meter.address, mcp9808RegConfig, current&^mcp9808Shutdown)
+	}
+
+	word := mcp9808Hysteresis[config.Hysteresis]
+	if alert := config.Alert; alert != nil {
+		limits := []float64{alert.Critical, alert.Lower, alert.Upper}
+		for i, reg := range []byte{mcp9808RegCritical, mcp9808RegLower, mcp9808RegUpper} {
+			if err = meter.bus.WriteWordToReg(meter.address, reg, mcp9808Limit(limits[i])); err != nil {
+				return err
+			}
+		}
+
+		word |= mcp9808AlertControl
+		if alert.CriticalOnly {
+			word |= mcp9808AlertSelect
+		}
+		if alert.ActiveHigh {
+			word |= mcp9808AlertPolarity
+		}
+	}
+	if err = meter.bus.WriteWordToReg(meter.address, mcp9808RegConfig, word); err != nil {
+		return err
+	}
+
+	// the lock bits can only be set once the rest of the configuration is
+	if config.Alert != nil && config.Alert.Lock {
+		return meter.bus.WriteWordToReg(meter.address, mcp9808RegConfig, word|mcp9808WindowLock|mcp9808CriticalLock)
+	}
+	return nil
+}
+
+// mcp9808Limit encodes an alert limit in °C, in steps of 0.25°C.
+func mcp9808Limit(celsius float64) uint16 {
+	return uint16(int16(math.Round(celsius*4))<<2) & 0x1FFC
 }
 
-// Shutdown is the deconstructor for an MCP9808.
+// mcp9808Celsius decodes a temperature register, which is 13 bit two's complement in 1/16°C.
+func mcp9808Celsius(word uint16) float64 {
+	return float64(int16(word<<3)>>3) / 16
+}
+
+// ReadTemperature reads the current ambient temperature.
+func (meter *MCP9808) ReadTemperature() (util.Temperature, error) {
+	word, err := meter.bus.ReadWordFromReg(meter.address, mcp9808RegAmbient)
+	if err != nil {
+		return util.Temperature{}, err
+	}
+	return util.Temperature{Degrees: mcp9808Celsius(word), Units: util.Celsius}, nil
+}
+
+// ReadAlerts reads which alert limits the current temperature is beyond.
+func (meter *MCP9808) ReadAlerts() (*MCP9808Alerts, error) {
+	word, err := meter.bus.ReadWordFromReg(meter.address, mcp9808RegAmbient)
+	if err != nil {
+		return nil, err
+	}
+	return &MCP9808Alerts{
+		AboveCritical: word&mcp9808AboveCritical != 0,
+		AboveUpper:    word&mcp9808AboveUpper != 0,
+		BelowLower:    word&mcp9808BelowLower != 0,
+	}, nil
+}
+
+// AlertLimits reads the critical, lower and upper alert limits in °C programmed into the sensor.
+func (meter *MCP9808) AlertLimits() (critical, lower, upper float64, err error) {
+	limits := make([]float64, 3)
+	for i, reg := range []byte{mcp9808RegCritical, mcp9808RegLower, mcp9808RegUpper} {
+		word, err := meter.bus.ReadWordFromReg(meter.address, reg)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		limits[i] = mcp9808Celsius(word)
+	}
+	return limits[0], limits[1], limits[2], nil
+}
+
+// Shutdown puts the MCP9808 into low power mode and releases the I2C bus.  A locked MCP9808 keeps converting so
+// that its alert output keeps working.
 func (meter *MCP9808) Shutdown() {
-	meter.sensor.SetShutdownMode(true)
-	embd.CloseI2C()
+	word, err := meter.bus.ReadWordFromReg(meter.address, mcp9808RegConfig)
+	if err == nil && word&(mcp9808WindowLock|mcp9808CriticalLock) == 0 {
+		err = meter.bus.WriteWordToReg(meter.address, mcp9808RegConfig, word|mcp9808Shutdown)
+	}
+	if err != nil {
+		log.Println("Error putting MCP9808 into shutdown mode: " + err.Error())
+	}
+	closeBus()
 }
@@ -0,0 +1,76 @@
+package thermometer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newFakeMCP9808(address byte) *fakeBus {
+	return newFakeBus(address, 2, map[byte][]byte{
+		mcp9808RegManufID:  {0x00, 0x54},
+		mcp9808RegDeviceID: {0x04, 0x00},
+		mcp9808RegConfig:   {0x01, 0x00}, // shut down
+	})
+}
+
+func TestMCP9808(t *testing.T) {
+	bus := newFakeMCP9808(0x1A)
+	if _, err := newMCP9808(bus, 0x18, &MCP9808Config{}); err != errNoDevice {
+		t.Errorf("Expected no device at the wrong address, got %v", err)
+	}
+
+	config := &MCP9808Config{Resolution: 0.25, Hysteresis: 1.5, Alert: &MCP9808Alert{Critical: 40, Lower: -2.5, Upper: 35.1, ActiveHigh: true, Lock: true}}
+	meter, err := newMCP9808(bus, 0x1A, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"0x8:01", "0x4:02 80", "0x3:1f d8", "0x2:02 30", "0x1:02 0a", "0x1:02 ca"}
+	if !reflect.DeepEqual(bus.writes, expected) {
+		t.Errorf("Expected the sensor programmed as %v, got %v", expected, bus.writes)
+	}
+	if critical, lower, upper, err := meter.AlertLimits(); err != nil || critical != 40 || lower != -2.5 || upper != 35 {
+		t.Errorf("Unexpected alert limits %v, %v, %v, %v", critical, lower, upper, err)
+	}
+
+	bus.set(0x1A, mcp9808RegAmbient, 0xC1, 0x94)
+	if temp, err := meter.ReadTemperature(); err != nil || temp.Degrees != 25.25 {
+		t.Errorf("Expected 25.25°C, got %v, %v", temp, err)
+	}
+	if alerts, err := meter.ReadAlerts(); err != nil || !reflect.DeepEqual(alerts, &MCP9808Alerts{AboveCritical: true, AboveUpper: true}) {
+		t.Errorf("Unexpected alerts %+v, %v", alerts, err)
+	}
+	bus.set(0x1A, mcp9808RegAmbient, 0x1F, 0xE0)
+	if temp, _ := meter.ReadTemperature(); temp.Degrees != -2 {
+		t.Errorf("Expected -2°C, got %v", temp)
+	}
+
+	// a locked sensor keeps its limits and keeps converting through shutdown
+	bus.writes = nil
+	if _, err = newMCP9808(bus, 0x1A, &MCP9808Config{}); err != nil {
+		t.Fatal(err)
+	}
+	meter.Shutdown()
+	if expected := []string{"0x8:03", "0x1:02 ca"}; !reflect.DeepEqual(bus.writes, expected) {
+		t.Errorf("Expected the locked configuration kept %v, got %v", expected, bus.writes)
+	}
+}
+
+func TestMCP9808Config(t *testing.T) {
+	for _, config := range []*MCP9808Config{
+		{Resolution: 0.1},
+		{Hysteresis: 2},
+		{Alert: &MCP9808Alert{Critical: 130, Upper: 30}},
+		{Alert: &MCP9808Alert{Critical: 40, Lower: 30, Upper: 20}},
+	} {
+		if err := config.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", config)
+		}
+	}
+	if err := (&MCP9808Config{Alert: &MCP9808Alert{Critical: 40, CriticalOnly: true}}).Validate(); err != nil {
+		t.Errorf("Expected a critical limit alone to be valid, got %v", err)
+	}
+
+	if _, err := NewMCP9808(&I2CConfig{Address: 0x76}, &MCP9808Config{}); err == nil {
+		t.Error("Expected an address outside of the MCP9808's to be rejected")
+	}
+}
@@ -0,0 +1,222 @@
+package thermometer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+// SamplerConfig defines how often a Sampler reads its thermometer and over how long it averages.
+type SamplerConfig struct {
+	// SensorID identifies the thermometer in the readings served.
+	SensorID string `json:"sensorId"`
+	// Interval is how often the thermometer is read, 10s by default.
+	Interval util.Duration `json:"interval"`
+	// Window averages the readings taken within this long, only the latest reading is used by default.
+	Window util.Duration `json:"window"`
+}
+
+const defaultInterval = 10 * time.Second
+
+// errNoReadings is returned until a Sampler has a recent reading.
+var errNoReadings = errors.New("no recent readings")
+
+// Sampler reads a thermometer in the background and serves the average of its recent readings, so that readers never
+// wait on the sensor and a single glitched reading is smoothed out.  It is a Thermometer itself.
+type Sampler struct {
+	meter    Thermometer
+	sensorID string
+	interval time.Duration
+	window   time.Duration
+	started  time.Time
+
+	mu                sync.Mutex
+	samples           []sample
+	lastError         error
+	lastErrorAt       time.Time
+	consecutiveErrors int
+	reads, failures   uint64
+}
+
+type sample struct {
+	celsius  float64
+	humidity *float64
+	at       time.Time
+}
+
+// Health describes how well a Sampler's thermometer is doing.
+type Health struct {
+	Healthy           bool          `json:"healthy"`
+	LastReading       *time.Time    `json:"lastReading,omitempty"`
+	LastError         string        `json:"lastError,omitempty"`
+	LastErrorAt       *time.Time    `json:"lastErrorAt,omitempty"`
+	ConsecutiveErrors int           `json:"consecutiveErrors"`
+	Samples           int           `json:"samples"`
+	Reads             uint64        `json:"reads"`
+	Failures          uint64        `json:"failures"`
+	Uptime            util.Duration `json:"uptime"`
+}
+
+// NewSampler creates a Sampler for meter.  Call Run to start sampling.
+func NewSampler(meter Thermometer, config *SamplerConfig) *Sampler {
+	sampler := &Sampler{
+		meter:    meter,
+		sensorID: config.SensorID,
+		interval: time.Duration(config.Interval),
+		window:   time.Duration(config.Window),
+		started:  time.Now(),
+	}
+	if sampler.interval <= 0 {
+		sampler.interval = defaultInterval
+	}
+	return sampler
+}
+
+// Run reads the thermometer every interval until ctx is done.
+func (s *Sampler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.sample(time.Now())
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Sampler) sample(now time.Time) {
+	temp, err := s.meter.ReadTemperature()
+	var humidity *float64
+	if hygrometer, ok := s.meter.(Hygrometer); ok && err == nil {
+		if relative, err := hygrometer.ReadHumidity(); err == nil {
+			humidity = &relative
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reads++
+	if err != nil {
+		s.failures++
+		s.consecutiveErrors++
+		s.lastError, s.lastErrorAt = err, now
+		return
+	}
+
+	s.consecutiveErrors = 0
+	s.samples = append(s.recent(now), sample{celsius: temp.Celsius(), humidity: humidity, at: now})
+}
+
+// recent returns the samples that are still fresh at now.  Must be called with mu held.
+func (s *Sampler) recent(now time.Time) []sample {
+	keep := s.samples[:0]
+	for _, sample := range s.samples {
+		if now.Sub(sample.at) < s.freshFor() {
+			keep = append(keep, sample)
+		}
+	}
+	return keep
+}
+
+// freshFor is how long a sample can be served for: the window, but at least a few intervals so that a missed reading
+// or two don't make the thermometer unavailable.
+func (s *Sampler) freshFor() time.Duration {
+	if fresh := 3 * s.interval; fresh > s.window {
+		return fresh
+	}
+	return s.window
+}
+
+// ReadTemperature returns the average of the readings in the window, or the latest reading if no window is
+// configured, in Celsius.
+func (s *Sampler) ReadTemperature() (util.Temperature, error) {
+	temp, _, err := s.read(time.Now())
+	return util.Temperature{Degrees: temp, Units: util.Celsius}, err
+}
+
+// read returns the averaged temperature and the latest sample.
+func (s *Sampler) read(now time.Time) (float64, *sample, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.samples = s.recent(now)
+	if len(s.samples) == 0 {
+		if s.lastError != nil {
+			return 0, nil, s.lastError
+		}
+		return 0, nil, errNoReadings
+	}
+
+	latest := s.samples[len(s.samples)-1]
+	if s.window <= 0 {
+		return latest.celsius, &latest, nil
+	}
+
+	var sum float64
+	var count int
+	for _, sample := range s.samples {
+		if now.Sub(sample.at) < s.window {
+			sum += sample.celsius
+			count++
+		}
+	}
+	if count == 0 {
+		return latest.celsius, &latest, nil
+	}
+	return sum / float64(count), &latest, nil
+}
+
+// Reading returns the current reading in the form served to JSONWebService clients.
+func (s *Sampler) Reading() *Reading {
+	temp, latest, err := s.read(time.Now())
+	reading := &Reading{Version: WireVersion, SensorID: s.sensorID, Units: util.Celsius}
+	switch {
+	case err == errNoReadings:
+		reading.Error = &ReadingError{Code: ErrorCodeNoReadings, Message: err.Error()}
+	case err != nil:
+		reading.Error = &ReadingError{Code: ErrorCodeSensor, Message: err.Error()}
+	default:
+		reading.Temperature, reading.Time, reading.Humidity = temp, latest.at, latest.humidity
+	}
+	return reading
+}
+
+// Health reports whether the thermometer has a recent reading and how often it failed.
+func (s *Sampler) Health() *Health {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.samples = s.recent(now)
+	health := &Health{
+		Healthy:           len(s.samples) > 0,
+		ConsecutiveErrors: s.consecutiveErrors,
+		Samples:           len(s.samples),
+		Reads:             s.reads,
+		Failures:          s.failures,
+		Uptime:            util.Duration(now.Sub(s.started)),
+	}
+	if len(s.samples) > 0 {
+		lastReading := s.samples[len(s.samples)-1].at
+		health.LastReading = &lastReading
+	}
+	if s.lastError != nil {
+		health.LastError = s.lastError.Error()
+		lastErrorAt := s.lastErrorAt
+		health.LastErrorAt = &lastErrorAt
+	}
+	return health
+}
+
+// Shutdown shuts the sampled thermometer down.
+func (s *Sampler) Shutdown() {
+	s.meter.Shutdown()
+}
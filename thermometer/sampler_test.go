@@ -0,0 +1,56 @@
+package thermometer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+func TestSampler(t *testing.T) {
+	meter := &scriptedThermometer{}
+	sampler := NewSampler(meter, &SamplerConfig{Interval: util.Duration(time.Minute), Window: util.Duration(5 * time.Minute)})
+
+	now := time.Now()
+	if _, _, err := sampler.read(now); err != errNoReadings {
+		t.Errorf("Expected no readings yet, got %v", err)
+	}
+
+	for i, temp := range []float64{20, 21, 22} {
+		meter.temp = temp
+		sampler.sample(now.Add(time.Duration(i) * time.Minute))
+	}
+	meter.err = errors.New("bus error")
+	sampler.sample(now.Add(3 * time.Minute))
+
+	if temp, latest, err := sampler.read(now.Add(3 * time.Minute)); err != nil || temp != 21 || !latest.at.Equal(now.Add(2*time.Minute)) {
+		t.Errorf("Expected the average of the window, got %v, %v", temp, err)
+	}
+	if temp, _, _ := sampler.read(now.Add(5*time.Minute + time.Second)); temp != 21.5 {
+		t.Errorf("Expected readings to leave the window, got %v", temp)
+	}
+
+	health := sampler.Health()
+	if health.Reads != 4 || health.Failures != 1 || health.ConsecutiveErrors != 1 || health.LastError != "bus error" {
+		t.Errorf("Unexpected health %+v", health)
+	}
+
+	if _, _, err := sampler.read(now.Add(time.Hour)); err == nil || err.Error() != "bus error" {
+		t.Errorf("Expected the last error once the readings went stale, got %v", err)
+	}
+	if reading := sampler.Reading(); reading.Error == nil || reading.Error.Code != ErrorCodeSensor {
+		t.Error("Served a stale reading as good.")
+	}
+}
+
+type scriptedThermometer struct {
+	temp float64
+	err  error
+}
+
+func (meter *scriptedThermometer) ReadTemperature() (util.Temperature, error) {
+	return util.Temperature{Degrees: meter.temp, Units: util.Celsius}, meter.err
+}
+
+func (meter *scriptedThermometer) Shutdown() {}
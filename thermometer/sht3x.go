@@ -0,0 +1,119 @@
+package thermometer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alittlebrighter/embd"
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+const (
+	sht3xDefaultAddress = 0x44
+	// sht3xMeasure is the single shot, high repeatability, no clock stretching measurement command, 0x2400.
+	sht3xMeasureMSB, sht3xMeasureLSB = 0x24, 0x00
+	// a high repeatability measurement takes up to 15ms
+	sht3xMeasurement = 16 * time.Millisecond
+	// sht3xStatus reads the status register, 0xF32D.
+	sht3xStatusMSB, sht3xStatusLSB = 0xF3, 0x2D
+)
+
+// SHT3x is a Sensirion SHT30/31/35 temperature and humidity sensor on an I2C bus.  Commands are written through
+// embd, but as embd can't read without writing a register address first the measurements are read from the bus'
+// Linux device directly.
+type SHT3x struct {
+	bus     embd.I2CBus
+	reader  i2cReader
+	address byte
+
+	mu sync.Mutex
+}
+
+// NewSHT3x connects to an SHT3x on the configured bus, at 0x44 unless another address is set.
+func NewSHT3x(config *I2CConfig) (*SHT3x, error) {
+	address, err := config.address(sht3xDefaultAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	meter, err := newSHT3x(config.bus(), newDevI2C(config.busNumber()), address)
+	if err != nil {
+		closeBus()
+	}
+	return meter, err
+}
+
+// newSHT3x reads the status register to make sure there is an SHT3x at address.
+func newSHT3x(bus embd.I2CBus, reader i2cReader, address byte) (*SHT3x, error) {
+	meter := &SHT3x{bus: bus, reader: reader, address: address}
+
+	if err := bus.WriteToReg(address, sht3xStatusMSB, []byte{sht3xStatusLSB}); err != nil {
+		return nil, err
+	}
+	status := make([]byte, 3)
+	if err := reader.ReadBytes(address, status); err != nil {
+		return nil, err
+	}
+	if sht3xCRC(status[0:2]) != status[2] {
+		return nil, fmt.Errorf("no SHT3x at %#x, status register failed its CRC check", address)
+	}
+	return meter, nil
+}
+
+// measure takes a single shot measurement and returns the temperature in Celsius and the relative humidity.
+func (meter *SHT3x) measure() (float64, float64, error) {
+	meter.mu.Lock()
+	defer meter.mu.Unlock()
+
+	if err := meter.bus.WriteToReg(meter.address, sht3xMeasureMSB, []byte{sht3xMeasureLSB}); err != nil {
+		return 0, 0, err
+	}
+	time.Sleep(sht3xMeasurement)
+
+	data := make([]byte, 6)
+	if err := meter.reader.ReadBytes(meter.address, data); err != nil {
+		return 0, 0, err
+	}
+	if sht3xCRC(data[0:2]) != data[2] || sht3xCRC(data[3:5]) != data[5] {
+		return 0, 0, errors.New("SHT3x reading failed its CRC check")
+	}
+
+	rawT := float64(uint16(data[0])<<8 | uint16(data[1]))
+	rawH := float64(uint16(data[3])<<8 | uint16(data[4]))
+	return -45 + 175*rawT/65535, 100 * rawH / 65535, nil
+}
+
+// sht3xCRC is the CRC-8 (polynomial 0x31, initialized to 0xFF) the SHT3x sends after every word.
+func sht3xCRC(data []byte) byte {
+	crc := byte(0xFF)
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x31
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// ReadTemperature measures the current temperature.
+func (meter *SHT3x) ReadTemperature() (util.Temperature, error) {
+	temp, _, err := meter.measure()
+	return util.Temperature{Degrees: temp, Units: util.Celsius}, err
+}
+
+// ReadHumidity measures the current relative humidity in percent.
+func (meter *SHT3x) ReadHumidity() (float64, error) {
+	_, humidity, err := meter.measure()
+	return humidity, err
+}
+
+// Shutdown releases the I2C bus, the SHT3x idles between single shot measurements anyway.
+func (meter *SHT3x) Shutdown() {
+	closeBus()
+}
@@ -0,0 +1,49 @@
+package thermometer
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestSHT3x(t *testing.T) {
+	if crc := sht3xCRC([]byte{0xBE, 0xEF}); crc != 0x92 {
+		t.Errorf("Expected the datasheet's CRC 0x92, got %#x", crc)
+	}
+
+	bus := newFakeBus(0x44, 1, nil)
+	if _, err := newSHT3x(bus, bus, 0x45); err != errNoDevice {
+		t.Errorf("Expected no device at the wrong address, got %v", err)
+	}
+	bus.raw = []byte{0x80, 0x10, 0x00}
+	if _, err := newSHT3x(bus, bus, 0x44); err == nil {
+		t.Error("Expected a status register that fails its CRC check to be rejected")
+	}
+	bus.writes = nil
+
+	// 0x6666 is 25°C, 0x8000 50% relative humidity, the status register reads the first word
+	bus.raw = []byte{0x66, 0x66, sht3xCRC([]byte{0x66, 0x66}), 0x80, 0x00, sht3xCRC([]byte{0x80, 0x00})}
+	meter, err := newSHT3x(bus, bus, 0x44)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if temp, err := meter.ReadTemperature(); err != nil || math.Abs(temp.Degrees-25) > 0.01 {
+		t.Errorf("Expected 25°C, got %v, %v", temp, err)
+	}
+	if humidity, err := meter.ReadHumidity(); err != nil || math.Abs(humidity-50) > 0.01 {
+		t.Errorf("Expected 50%%, got %v, %v", humidity, err)
+	}
+	if expected := []string{"0xf3:2d", "0x24:00", "0x24:00"}; !reflect.DeepEqual(bus.writes, expected) {
+		t.Errorf("Expected a status read and single shot measurements %v, got %v", expected, bus.writes)
+	}
+
+	bus.raw[5]++
+	if _, err := meter.ReadTemperature(); err == nil {
+		t.Error("Expected a CRC error")
+	}
+
+	meter.address = 0x45
+	if _, err := meter.ReadTemperature(); err != errNoDevice {
+		t.Errorf("Expected no device at the wrong address, got %v", err)
+	}
+}
@@ -6,16 +6,32 @@ import (
 
 // Thermometer defines the basic functions needed of a thermometer.
 type Thermometer interface {
-	ReadTemperature() (float64, util.TemperatureUnits, error)
+	ReadTemperature() (util.Temperature, error)
 	Shutdown()
 }
 
-// NewLocal returns a pointer to a local thermometer instance that can be used.
+// Hygrometer is implemented by thermometers that also measure relative humidity, in percent.
+type Hygrometer interface {
+	ReadHumidity() (float64, error)
+}
+
+// RawReader is implemented by thermometers that correct their readings, so that what the sensor actually read can be
+// checked before it is corrected.
+type RawReader interface {
+	// ReadRaw reads the sensor without correcting the reading.
+	ReadRaw() (util.Temperature, error)
+	// Correct returns raw, a reading ReadRaw returned, corrected.  Only pass readings that were accepted: they count
+	// towards the smoothing of later ones.
+	Correct(raw util.Temperature) util.Temperature
+}
+
+// NewLocal returns a pointer to a local thermometer instance that can be used, an MCP9808 at its default address on
+// I2C bus 1.
 func NewLocal() (Thermometer, error) {
-	return NewMCP9808()
+	return NewMCP9808(new(I2CConfig), new(MCP9808Config))
 }
 
 // NewRemote returns a pointer to a thermometer service hosted remotely.
-func NewRemote(endpoint string) (Thermometer, error) {
-	return NewJSONWebService(endpoint)
+func NewRemote(config *WebServiceConfig) (Thermometer, error) {
+	return NewJSONWebService(config)
 }
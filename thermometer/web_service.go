@@ -1,70 +1,176 @@
 package thermometer
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/alittlebrighter/thermostat/util"
 )
 
-// JSONWebService reads temperature values from a remote location through a JSON API call over http.
+// JSONWebService reads temperature values from a remote location through a JSON API call over http.  Failed requests
+// are retried with exponential backoff and, if configured, the last good reading stands in for a while when the
+// service can't be reached at all.
 type JSONWebService struct {
-	client  *http.Client
-	request *http.Request
+	client      *http.Client
+	endpoint    string
+	maxAge      time.Duration
+	timeout     time.Duration
+	retries     int
+	backoff     time.Duration
+	cacheMaxAge time.Duration
+
+	mu       sync.Mutex
+	lastGood *cachedReading
+}
+
+type cachedReading struct {
+	temp util.Temperature
+	at   time.Time
+}
+
+// WebServiceConfig defines where and how to reach a remote thermometer.
+type WebServiceConfig struct {
+	Endpoint string         `json:"endpoint"`
+	TLS      util.TLSConfig `json:"tls"`
+	// MaxAge rejects readings that were taken longer ago than this, e.g. a value the service has been caching.
+	// Only readings that say when they were taken can be checked.
+	MaxAge util.Duration `json:"maxAge"`
+	// Timeout bounds every request, 5s by default.
+	Timeout util.Duration `json:"timeout"`
+	// Retries is how many more times a failed request is attempted, 2 by default.  Keep Retries, Timeout and Backoff
+	// well within the thermostat's PollInterval.
+	Retries *int `json:"retries"`
+	// Backoff is how long to wait before the first retry, doubling with every retry after that.  500ms by default.
+	Backoff util.Duration `json:"backoff"`
+	// CacheMaxAge lets the last good reading stand in for a failed one as long as it is no older than this.
+	CacheMaxAge util.Duration `json:"cacheMaxAge"`
 }
 
+const (
+	defaultTimeout = 5 * time.Second
+	defaultRetries = 2
+	defaultBackoff = 500 * time.Millisecond
+	// maxResponseSize bounds how much of a response is read.
+	maxResponseSize = 1 << 16
+)
+
 // NewJSONWebService constructs a JSONWebService.
-func NewJSONWebService(endpoint string) (*JSONWebService, error) {
-	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+func NewJSONWebService(config *WebServiceConfig) (*JSONWebService, error) {
+	if _, err := http.NewRequest(http.MethodGet, config.Endpoint, nil); err != nil {
+		return nil, err
+	}
+
+	client := http.DefaultClient
+	tlsConfig, err := config.TLS.ClientConfig()
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Accept", "application/json")
-	thermometer := &JSONWebService{client: http.DefaultClient, request: req}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		client = &http.Client{Transport: transport}
+	}
+
+	thermometer := &JSONWebService{
+		client:      client,
+		endpoint:    config.Endpoint,
+		maxAge:      time.Duration(config.MaxAge),
+		timeout:     time.Duration(config.Timeout),
+		retries:     defaultRetries,
+		backoff:     time.Duration(config.Backoff),
+		cacheMaxAge: time.Duration(config.CacheMaxAge),
+	}
+	if config.Retries != nil {
+		thermometer.retries = *config.Retries
+	}
+	if thermometer.timeout <= 0 {
+		thermometer.timeout = defaultTimeout
+	}
+	if thermometer.backoff <= 0 {
+		thermometer.backoff = defaultBackoff
+	}
 
 	return thermometer, nil
 }
 
 // ReadTemperature calls out to the configured web service to obtain a temperature reading.
-func (meter *JSONWebService) ReadTemperature() (float64, util.TemperatureUnits, error) {
-	resp, err := meter.client.Do(meter.request)
+func (meter *JSONWebService) ReadTemperature() (util.Temperature, error) {
+	backoff := meter.backoff
+	for attempt := 0; ; attempt++ {
+		temp, retry, err := meter.fetch()
+		if err == nil {
+			meter.mu.Lock()
+			meter.lastGood = &cachedReading{temp: temp, at: time.Now()}
+			meter.mu.Unlock()
+			return temp, nil
+		}
+		if !retry || attempt >= meter.retries {
+			return meter.cached(err)
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// fetch makes a single request and reports whether it is worth retrying if it failed.
+func (meter *JSONWebService) fetch() (temp util.Temperature, retry bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), meter.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, meter.endpoint, nil)
+	if err != nil {
+		return temp, false, err
+	}
+	req.Header.Add("Accept", "application/json")
+
+	resp, err := meter.client.Do(req)
 	if err != nil {
-		return 0, util.Celsius, err
+		return temp, true, err
 	}
 	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
 	if err != nil {
-		return 0, util.Celsius, err
+		return temp, true, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return temp, retry, fmt.Errorf("thermometer responded %s", resp.Status)
 	}
 
-	tempReading := new(TemperatureReading)
-	err = json.Unmarshal(body, tempReading)
+	reading, err := DecodeReading(body)
 	if err != nil {
-		return 0, util.Celsius, err
+		return temp, false, err
+	}
+	if reading.Error != nil {
+		// the sensor itself failed, it may well succeed on the next try
+		return temp, true, reading.Error
 	}
 
-	return tempReading.Explode()
+	if meter.maxAge > 0 && !reading.Time.IsZero() && time.Since(reading.Time) > meter.maxAge {
+		return temp, false, fmt.Errorf("stale reading taken at %s", reading.Time.Format(time.RFC3339))
+	}
+	return reading.Measured(), false, nil
 }
 
-// Explode returns the elements of a TemperatureReading into individual parameters.
-func (r *TemperatureReading) Explode() (float64, util.TemperatureUnits, error) {
-	var err error
-	if r.Error == "<nil>" {
-		err = nil
-	} else {
-		err = errors.New(r.Error)
+// cached returns the last good reading in place of err if it is recent enough.
+func (meter *JSONWebService) cached(err error) (util.Temperature, error) {
+	meter.mu.Lock()
+	defer meter.mu.Unlock()
+
+	if meter.cacheMaxAge <= 0 || meter.lastGood == nil || time.Since(meter.lastGood.at) > meter.cacheMaxAge {
+		return util.Temperature{}, err
 	}
-	return r.Temperature, r.Units, err
+	log.Printf("Using the reading from %s, thermometer failed: %s", meter.lastGood.at.Format(time.Kitchen), err.Error())
+	return meter.lastGood.temp, nil
 }
 
 // Shutdown exists for the JSONWebService purely to satisfy the Thermometer interface
 func (meter *JSONWebService) Shutdown() {}
-
-// TemperatureReading exists to make deserialization of the web service call easier.
-type TemperatureReading struct {
-	Temperature float64
-	Units       util.TemperatureUnits
-	Error       string
-}
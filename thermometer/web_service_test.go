@@ -0,0 +1,221 @@
+package thermometer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+func TestJSONWebServiceMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeTestCert(t, dir, "ca", nil, nil)
+	writeTestCert(t, dir, "server", ca, caKey)
+	writeTestCert(t, dir, "client", ca, caKey)
+
+	serverTLS := &util.TLSConfig{
+		Cert: filepath.Join(dir, "server.pem"),
+		Key:  filepath.Join(dir, "server-key.pem"),
+		CA:   filepath.Join(dir, "ca.pem"),
+	}
+	var err error
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Temperature": 21.5, "Units": "Celsius", "Error": "<nil>"}`)
+	}))
+	if server.TLS, err = serverTLS.ServerConfig(); err != nil {
+		t.Fatal(err)
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	meter, err := NewJSONWebService(&WebServiceConfig{
+		Endpoint: server.URL,
+		TLS: util.TLSConfig{
+			Cert: filepath.Join(dir, "client.pem"),
+			Key:  filepath.Join(dir, "client-key.pem"),
+			CA:   filepath.Join(dir, "ca.pem"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if temp, err := meter.ReadTemperature(); err != nil || temp.Degrees != 21.5 {
+		t.Errorf("Failed to read temperature over mutual TLS: %v %v", temp, err)
+	}
+
+	retries := 0
+	anonymous, err := NewJSONWebService(&WebServiceConfig{
+		Endpoint: server.URL,
+		TLS:      util.TLSConfig{CA: filepath.Join(dir, "ca.pem")},
+		Retries:  &retries,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := anonymous.ReadTemperature(); err == nil {
+		t.Error("Server accepted a client without a certificate.")
+	}
+}
+
+func TestJSONWebServiceStaleReading(t *testing.T) {
+	taken := time.Now().Add(-time.Hour)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"Temperature": 21.5, "Units": "Celsius", "Error": "<nil>", "Time": %q}`, taken.Format(time.RFC3339))
+	}))
+	defer server.Close()
+
+	meter, err := NewJSONWebService(&WebServiceConfig{Endpoint: server.URL, MaxAge: util.Duration(10 * time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := meter.ReadTemperature(); err == nil {
+		t.Error("Accepted a reading taken an hour ago.")
+	}
+
+	taken = time.Now()
+	if _, err := meter.ReadTemperature(); err != nil {
+		t.Error("Rejected a fresh reading: " + err.Error())
+	}
+}
+
+func TestJSONWebServiceRetries(t *testing.T) {
+	var status int32 = http.StatusServiceUnavailable
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.WriteHeader(int(atomic.LoadInt32(&status)))
+			fmt.Fprint(w, `{"Temperature": 99, "Units": "Celsius", "Error": "<nil>"}`)
+			return
+		}
+		fmt.Fprint(w, `{"Temperature": 21.5, "Units": "Celsius", "Error": "<nil>"}`)
+	}))
+	defer server.Close()
+
+	retries := 2
+	meter, err := NewJSONWebService(&WebServiceConfig{Endpoint: server.URL, Retries: &retries, Backoff: util.Duration(time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if temp, err := meter.ReadTemperature(); err != nil || temp.Degrees != 21.5 {
+		t.Errorf("Failed to read temperature on the last retry: %v %v", temp, err)
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	atomic.StoreInt32(&status, http.StatusNotFound)
+	if _, err := meter.ReadTemperature(); err == nil {
+		t.Error("Parsed the body of an error response.")
+	}
+	if requests != 1 {
+		t.Errorf("Retried a request that can't succeed %d times.", requests-1)
+	}
+}
+
+func TestJSONWebServiceTimeoutAndCache(t *testing.T) {
+	hang := make(chan struct{})
+	var hung int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&hung) == 1 {
+			select {
+			case <-hang:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		fmt.Fprint(w, `{"Temperature": 21.5, "Units": "Celsius", "Error": "<nil>"}`)
+	}))
+	defer server.Close()
+	defer close(hang)
+
+	retries := 0
+	config := &WebServiceConfig{Endpoint: server.URL, Retries: &retries, Timeout: util.Duration(50 * time.Millisecond)}
+	meter, err := NewJSONWebService(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := meter.ReadTemperature(); err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreInt32(&hung, 1)
+	start := time.Now()
+	if _, err := meter.ReadTemperature(); err == nil {
+		t.Error("Expected a hung thermometer to time out.")
+	}
+	if time.Since(start) > time.Second {
+		t.Error("Request to a hung thermometer was not cut short.")
+	}
+
+	meter.cacheMaxAge = time.Minute
+	if temp, err := meter.ReadTemperature(); err != nil || temp.Degrees != 21.5 {
+		t.Errorf("Expected the last good reading, got %v %v", temp, err)
+	}
+	meter.lastGood.at = time.Now().Add(-2 * time.Minute)
+	if _, err := meter.ReadTemperature(); err == nil {
+		t.Error("Used a cached reading older than its max age.")
+	}
+}
+
+// writeTestCert writes name.pem and name-key.pem to dir, signed by parent or self-signed as a CA if parent is nil.
+func writeTestCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".pem"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+"-key.pem"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
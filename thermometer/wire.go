@@ -0,0 +1,119 @@
+package thermometer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+// WireVersion is the version of the Reading format served by thermometer-server and understood by JSONWebService.
+// Readings without a version are in the legacy TemperatureReading format.
+const WireVersion = 2
+
+// Reading is a remote thermometer's reading on the wire.
+type Reading struct {
+	Version int `json:"version"`
+	// SensorID identifies the sensor that took the reading.
+	SensorID    string                `json:"sensorId,omitempty"`
+	Time        time.Time             `json:"time"`
+	Temperature float64               `json:"temperature"`
+	Units       util.TemperatureUnits `json:"units"`
+	// Humidity is the relative humidity in percent, if the sensor measures it.
+	Humidity *float64 `json:"humidity,omitempty"`
+	// Battery is the charge left in percent, if the sensor runs on batteries.
+	Battery *float64 `json:"battery,omitempty"`
+	// Error is set instead of the measurements if the sensor could not be read.
+	Error *ReadingError `json:"error,omitempty"`
+}
+
+// ReadingError describes why a sensor could not be read.
+type ReadingError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ReadingError codes.
+const (
+	// ErrorCodeSensor means the sensor failed to take a reading.
+	ErrorCodeSensor = "sensor"
+	// ErrorCodeNoReadings means the server has no recent reading to serve.
+	ErrorCodeNoReadings = "noReadings"
+	// ErrorCodeUnknown is used for legacy readings, which only have a message.
+	ErrorCodeUnknown = "unknown"
+)
+
+func (e *ReadingError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// TemperatureReading is the legacy, unversioned wire format in which "<nil>" as the Error means success.  It is still
+// understood by JSONWebService and served by thermometer-server on request.
+type TemperatureReading struct {
+	Temperature float64
+	Units       util.TemperatureUnits
+	Error       string
+	// Time is when the reading was taken, if the service says.
+	Time time.Time
+}
+
+// Explode returns the elements of a TemperatureReading into individual parameters.
+func (r *TemperatureReading) Explode() (util.Temperature, error) {
+	var err error
+	if r.Error == "<nil>" {
+		err = nil
+	} else {
+		err = errors.New(r.Error)
+	}
+	return util.Temperature{Degrees: r.Temperature, Units: r.Units}, err
+}
+
+// Measured returns the temperature of the reading.
+func (r *Reading) Measured() util.Temperature {
+	return util.Temperature{Degrees: r.Temperature, Units: r.Units}
+}
+
+// Legacy returns the reading in the legacy wire format.
+func (r *Reading) Legacy() *TemperatureReading {
+	legacy := &TemperatureReading{Temperature: r.Temperature, Units: r.Units, Error: "<nil>", Time: r.Time}
+	if r.Error != nil {
+		legacy.Error = r.Error.Message
+	}
+	return legacy
+}
+
+// DecodeReading parses a reading in either wire format and checks that successful readings are in known units.
+func DecodeReading(data []byte) (*Reading, error) {
+	version := new(struct {
+		Version *int `json:"version"`
+	})
+	if err := json.Unmarshal(data, version); err != nil {
+		return nil, err
+	}
+
+	reading := new(Reading)
+	switch {
+	case version.Version == nil:
+		legacy := new(TemperatureReading)
+		if err := json.Unmarshal(data, legacy); err != nil {
+			return nil, err
+		}
+		reading = &Reading{Time: legacy.Time, Temperature: legacy.Temperature, Units: legacy.Units}
+		if legacy.Error != "<nil>" {
+			reading.Error = &ReadingError{Code: ErrorCodeUnknown, Message: legacy.Error}
+		}
+	case *version.Version == WireVersion:
+		if err := json.Unmarshal(data, reading); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported reading version %d", *version.Version)
+	}
+
+	if reading.Error == nil && !reading.Units.Valid() {
+		return nil, fmt.Errorf("unknown temperature units %q", reading.Units)
+	}
+	return reading, nil
+}
@@ -0,0 +1,53 @@
+package thermometer
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+func TestDecodeReading(t *testing.T) {
+	reading, err := DecodeReading([]byte(`{"Temperature": 21.5, "Units": "Celsius", "Error": "<nil>"}`))
+	if err != nil || reading.Temperature != 21.5 || reading.Error != nil {
+		t.Errorf("Failed to decode a legacy reading: %+v %v", reading, err)
+	}
+
+	reading, err = DecodeReading([]byte(`{"Temperature": 0, "Units": "", "Error": "i2c timeout"}`))
+	if err != nil || reading.Error == nil || reading.Error.Message != "i2c timeout" {
+		t.Errorf("Failed to decode a legacy error: %+v %v", reading, err)
+	}
+
+	reading, err = DecodeReading([]byte(`{"version": 2, "sensorId": "hall", "time": "2020-01-01T00:00:00Z", "temperature": 70, "units": "Fahrenheit", "humidity": 40}`))
+	if err != nil || reading.SensorID != "hall" || reading.Units != util.Fahrenheit || reading.Humidity == nil || *reading.Humidity != 40 {
+		t.Errorf("Failed to decode a reading: %+v %v", reading, err)
+	}
+
+	reading, err = DecodeReading([]byte(`{"version": 2, "error": {"code": "sensor", "message": "bus error"}}`))
+	if err != nil || reading.Error == nil || reading.Error.Code != ErrorCodeSensor {
+		t.Errorf("Failed to decode an error: %+v %v", reading, err)
+	}
+
+	if _, err := DecodeReading([]byte(`{"version": 3, "temperature": 21}`)); err == nil {
+		t.Error("Accepted an unknown version.")
+	}
+	if _, err := DecodeReading([]byte(`{"Temperature": 21.5, "Units": "Celcius", "Error": "<nil>"}`)); err == nil {
+		t.Error("Accepted unknown units.")
+	}
+}
+
+func TestLegacyReading(t *testing.T) {
+	reading := &Reading{Version: WireVersion, Temperature: 21.5, Units: util.Celsius, Error: &ReadingError{Code: ErrorCodeSensor, Message: "bus error"}}
+	data, err := json.Marshal(reading.Legacy())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacy := new(TemperatureReading)
+	if err := json.Unmarshal(data, legacy); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := legacy.Explode(); err == nil || err.Error() != "bus error" {
+		t.Errorf("Expected the error to survive the legacy format, got %v", err)
+	}
+}
@@ -1,8 +1,11 @@
 package thermostat
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/alittlebrighter/thermostat/controller"
@@ -13,54 +16,377 @@ import (
 type Config struct {
 	Thermostat  *Thermostat
 	Controller  struct{ Pins struct{ Fan, Cool, Heat int } }
-	Thermometer struct{ Type, Endpoint string }
+	Thermometer struct {
+		ThermometerConfig
+		// Secondary is read while the thermometer can't be.
+		Secondary *ThermometerConfig
+	}
+}
+
+// ThermometerConfig selects a thermometer and configures it.  Type is "local" for the MCP9808, "ds18b20", "bme280",
+// "sht3x" or "remote", the default.
+type ThermometerConfig struct {
+	Type string
+	tmeter.WebServiceConfig
+	// I2C locates the MCP9808, BME280 or SHT3x.
+	I2C     tmeter.I2CConfig     `json:"i2c"`
+	MCP9808 tmeter.MCP9808Config `json:"mcp9808"`
+	DS18B20 tmeter.DS18B20Config `json:"ds18b20"`
+	// Calibration and Smoothing correct the thermometer's readings before the thermostat acts on them.
+	Calibration *tmeter.CalibrationConfig `json:"calibration"`
+	Smoothing   *tmeter.SmoothingConfig   `json:"smoothing"`
+}
+
+// NewThermometer sets up the configured thermometer.
+func (config *ThermometerConfig) NewThermometer() (tmeter.Thermometer, error) {
+	meter, err := config.newSensor()
+	if err != nil || (config.Calibration == nil && config.Smoothing == nil) {
+		return meter, err
+	}
+
+	calibrated, err := tmeter.NewCalibrated(meter, config.Calibration, config.Smoothing)
+	if err != nil {
+		meter.Shutdown()
+		return nil, err
+	}
+	return calibrated, nil
+}
+
+func (config *ThermometerConfig) newSensor() (tmeter.Thermometer, error) {
+	switch config.Type {
+	case "local":
+		return tmeter.NewMCP9808(&config.I2C, &config.MCP9808)
+	case "ds18b20":
+		return tmeter.NewDS18B20(&config.DS18B20)
+	case "bme280":
+		return tmeter.NewBME280(&config.I2C)
+	case "sht3x":
+		return tmeter.NewSHT3x(&config.I2C)
+	case "", "remote":
+		return tmeter.NewRemote(&config.WebServiceConfig)
+	}
+	return nil, fmt.Errorf("unknown thermometer type %q", config.Type)
 }
 
 // Thermostat is the primary struct that contains all of the data required to operate a smart thermostat system.
+// Once Run has been started the configuration must only be changed through Configure.
 type Thermostat struct {
-	Modes                 `json:"modes"`
-	DefaultMode           string                `json:"defaultMode"`
-	Schedule              []*ScheduleEvent      `json:"schedule"`
-	Overshoot             float64               `json:"overshoot"`
-	PollInterval          util.Duration         `json:"pollInterval"`
-	MinFan                util.Duration         `json:"minFan"`
-	LastFan               time.Time             `json:"lastFan"`
-	MaxErrors, errorCount uint8                 `json:"maxErrors"`
-	UnitPreference        util.TemperatureUnits `json:"unitPreference"`
-	control               controller.Controller
-	thermometer           tmeter.Thermometer
-	Events                *util.RingBuffer `json:"events"`
+	Modes          `json:"modes"`
+	DefaultMode    string                `json:"defaultMode"`
+	Schedule       []*ScheduleEvent      `json:"schedule"`
+	Hold           *Hold                 `json:"hold,omitempty"`
+	Overshoot      float64               `json:"overshoot"`
+	PollInterval   util.Duration         `json:"pollInterval"`
+	MinFan         util.Duration         `json:"minFan"`
+	MinOff         util.Duration         `json:"minOff"`
+	LastFan        time.Time             `json:"lastFan"`
+	MaxErrors      uint8                 `json:"maxErrors"`
+	UnitPreference util.TemperatureUnits `json:"unitPreference"`
+	// Units are what the temperatures of the configuration are given in, UnitPreference by default.  They are
+	// converted to UnitPreference when the configuration is applied, so changing UnitPreference alone converts them.
+	Units       util.TemperatureUnits `json:"units,omitempty"`
+	Diagnostics *Diagnostics          `json:"diagnostics,omitempty"`
+	Sanity      *SanityChecks         `json:"sanity,omitempty"`
+	Failure     *FailurePolicy        `json:"failure,omitempty"`
+	// Safety are hard limits the temperature is kept within regardless of modes, holds and the schedule.
+	Safety *Window          `json:"safety,omitempty"`
+	Events *util.RingBuffer `json:"events"`
+	// Revision is incremented every time the configuration changes.
+	Revision uint64 `json:"revision"`
+
+	mu            sync.RWMutex
+	errorCount    int
+	errorTimes    []time.Time
+	control       controller.Controller
+	thermometer   tmeter.Thermometer
+	secondary     tmeter.Thermometer
+	secondaryDown bool
+	degraded      *Degraded
+	breach        *SafetyBreach
+	reconfigured  chan struct{}
+	audit         []*AuditEntry
+	onChange      func(*AuditEntry, *Thermostat)
+	// changes are accepted changes waiting for onChange, oldest first.  They are delivered after mu is released.
+	changesMu    sync.Mutex
+	changes      []*pendingChange
+	delivering   bool
+	stream       *util.Stream
+	since        time.Time
+	lastOff      time.Time
+	onRunState   func(*RunState)
+	checkpoint   *checkpoint
+	fault        *Fault
+	lastValue    *sample
+	lastAccepted *sample
+	// raw is the uncorrected value of the reading being processed, if its thermometer is calibrated.
+	raw *util.Temperature
+}
+
+// Types of the StreamEvents a Thermostat publishes.
+const (
+	// StreamReading carries the util.EventLog of every temperature reading.
+	StreamReading = "reading"
+	// StreamError carries a util.EventLog with the error in its Message.
+	StreamError = "error"
+	// StreamRestart carries a util.EventLog recording an unexpected restart.
+	StreamRestart = "restart"
+	// StreamDirection carries the RunState every time the HVAC system changes direction.
+	StreamDirection = "direction"
+	// StreamConfig carries the AuditEntry of every accepted configuration change.
+	StreamConfig = "config"
+	// StreamDegraded carries the Degraded state every time too many failed readings change what the thermostat falls
+	// back to, or nil once it recovers.
+	StreamDegraded = "degraded"
+	// StreamSafety carries the SafetyBreach when the temperature goes beyond the Safety limits, or nil once it is
+	// back within them.
+	StreamSafety = "safety"
+	// StreamFault carries the Fault found by Diagnostics, or nil once it is cleared.
+	StreamFault = "fault"
+)
+
+// RunState is what the thermostat needs to remember across restarts to pick up where it left off.
+type RunState struct {
+	controller.State
+	LastFan time.Time `json:"lastFan"`
 }
 
 // Modes are a collection of Windows referenced by a string label/key
 type Modes map[string]*Window
 
-// Window defines low and high temperatures
+// Window defines low and high temperatures.  Both are in the same units, those of the configuration the window is part
+// of if they have none.  Windows are marshaled as numbers with the units alongside, see MarshalJSON.
 type Window struct {
-	LowTemp  float64 `json:"low"`
-	HighTemp float64 `json:"high"`
+	Low  util.Temperature
+	High util.Temperature
+}
+
+// Hold overrides the schedule with the mode called Mode until Until, or indefinitely if Until is zero.
+type Hold struct {
+	Mode  string    `json:"mode"`
+	Until time.Time `json:"until"`
+}
+
+// Active reports whether the hold applies at t.
+func (h *Hold) Active(t time.Time) bool {
+	return h != nil && (h.Until.IsZero() || t.Before(h.Until))
 }
 
 // ScheduleEvent defines a block of time from Start to End on the specified Days each week when the specified
 // mode (ModeName) should be applied.
 type ScheduleEvent struct {
+	ID       string         `json:"id"`
 	Days     []time.Weekday `json:"days"`
 	ModeName string         `json:"mode"`
 	Start    util.ClockTime `json:"start"`
 	End      util.ClockTime `json:"end"`
 }
 
+// UnmarshalJSON assigns a new ID to events that don't have one yet so that they can be addressed individually.
+func (spec *ScheduleEvent) UnmarshalJSON(data []byte) error {
+	type scheduleEvent ScheduleEvent
+	if err := json.Unmarshal(data, (*scheduleEvent)(spec)); err != nil {
+		return err
+	}
+	if spec.ID == "" {
+		spec.ID = util.NewID()
+	}
+	return nil
+}
+
+// copy returns a copy of spec that doesn't share its days.
+func (spec *ScheduleEvent) copy() *ScheduleEvent {
+	copied := *spec
+	copied.Days = append([]time.Weekday(nil), spec.Days...)
+	return &copied
+}
+
 func (stat *Thermostat) SetController(c controller.Controller) {
+	stat.mu.Lock()
+	defer stat.mu.Unlock()
 	stat.control = c
 }
 
 func (stat *Thermostat) SetThermometer(t tmeter.Thermometer) {
+	stat.mu.Lock()
+	defer stat.mu.Unlock()
 	stat.thermometer = t
 }
 
+// SetStream sets where the thermostat publishes readings, errors, direction and configuration changes as they happen.
+func (stat *Thermostat) SetStream(stream *util.Stream) {
+	stat.mu.Lock()
+	defer stat.mu.Unlock()
+	stat.stream = stream
+}
+
+// publish must be called with mu held.
+func (stat *Thermostat) publish(eventType string, data interface{}) {
+	if stat.stream != nil {
+		stat.stream.Publish(eventType, data)
+	}
+}
+
+// logEvent adds event to the event log and publishes it.  Must be called with mu held.
+func (stat *Thermostat) logEvent(eventType string, event *util.EventLog) {
+	stat.Events.Add(event)
+	stat.publish(eventType, event)
+}
+
+// SetRunStateListener registers a function that is called with the new RunState every time the thermostat changes
+// the direction of the HVAC system, e.g. to persist it.
+func (stat *Thermostat) SetRunStateListener(listener func(*RunState)) {
+	stat.mu.Lock()
+	defer stat.mu.Unlock()
+	stat.onRunState = listener
+}
+
+// CurrentRunState returns the current direction of the HVAC system, when it was commanded and the fan duty state.
+func (stat *Thermostat) CurrentRunState() *RunState {
+	stat.mu.RLock()
+	defer stat.mu.RUnlock()
+	return stat.runState()
+}
+
+func (stat *Thermostat) runState() *RunState {
+	return &RunState{
+		State:   controller.State{Direction: stat.control.Direction(), Since: stat.since},
+		LastFan: stat.LastFan,
+	}
+}
+
+// Recover reconciles the thermostat with the RunState it was in before the process last stopped instead of starting
+// from scratch.  The controller is expected to be off.  An unexpected restart is recorded in the event log, heating and
+// cooling are held off for MinOff since they may have only just been cut, and an interrupted fan duty cycle is resumed.
+func (stat *Thermostat) Recover(state *RunState) {
+	stat.mu.Lock()
+	previous := stat.control.Direction()
+	now := time.Now()
+	stat.LastFan = state.LastFan
+	stat.since = now
+
+	if !state.CleanShutdown {
+		log.Printf("Recovering from an unexpected restart, was %s since %v.", state.Direction, state.Since)
+		stat.logEvent(StreamRestart, &util.EventLog{AmbientTemperature: stat.temperature(-1), Direction: state.Direction, Message: "unexpected restart"})
+	}
+
+	switch state.Direction {
+	case controller.Heating, controller.Cooling:
+		stat.lastOff = now
+	case controller.Fan:
+		if time.Duration(stat.MinFan) > 0 && stat.LastFan.After(now) {
+			log.Println("resuming FAN")
+			stat.control.Fan()
+			stat.since = state.Since
+			stat.publish(StreamDirection, stat.runState())
+		}
+	}
+	stat.notifyRunState(previous)
+}
+
+// notifyRunState unlocks mu and then calls the RunState listener if the direction changed from previous.  Must be
+// called with mu held.
+func (stat *Thermostat) notifyRunState(previous controller.ThermoDirection) {
+	state, listener := stat.runState(), stat.onRunState
+	stat.mu.Unlock()
+
+	if listener != nil && state.Direction != previous {
+		listener(state)
+	}
+}
+
+// Configure replaces the configuration of the thermostat with that of update.  It is safe to call while Run is
+// active; the new configuration takes effect on the next temperature reading and the polling ticker is re-armed if
+// the PollInterval changed.
+func (stat *Thermostat) Configure(update *Thermostat) {
+	stat.mu.Lock()
+	defer stat.mu.Unlock()
+	stat.configure(update)
+}
+
+// configure must be called with mu held.
+func (stat *Thermostat) configure(update *Thermostat) {
+	pollChanged := stat.PollInterval != update.PollInterval
+
+	stat.replaceWith(update)
+	stat.Revision++
+
+	if pollChanged {
+		select {
+		case stat.reconfiguredChan() <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Copy returns a snapshot of the thermostat's configuration that does not share its event log, controller or
+// thermometer.
+func (stat *Thermostat) Copy() *Thermostat {
+	stat.mu.RLock()
+	defer stat.mu.RUnlock()
+	return stat.copy()
+}
+
+// copy must be called with mu held.
+func (stat *Thermostat) copy() *Thermostat {
+	return &Thermostat{
+		Modes:          stat.Modes,
+		DefaultMode:    stat.DefaultMode,
+		Schedule:       stat.Schedule,
+		Hold:           stat.Hold,
+		Overshoot:      stat.Overshoot,
+		PollInterval:   stat.PollInterval,
+		MinFan:         stat.MinFan,
+		MinOff:         stat.MinOff,
+		LastFan:        stat.LastFan,
+		MaxErrors:      stat.MaxErrors,
+		UnitPreference: stat.UnitPreference,
+		Units:          stat.Units,
+		Diagnostics:    stat.Diagnostics,
+		Sanity:         stat.Sanity,
+		Failure:        stat.Failure,
+		Safety:         stat.Safety,
+		Revision:       stat.Revision,
+	}
+}
+
+// MarshalJSON serializes the thermostat while holding its lock so that a running control loop can't change it midway.
+func (stat *Thermostat) MarshalJSON() ([]byte, error) {
+	stat.mu.RLock()
+	defer stat.mu.RUnlock()
+
+	type thermostat Thermostat
+	return json.Marshal((*thermostat)(stat))
+}
+
+// reconfiguredChan lazily creates the channel used to tell Run that the PollInterval changed.  Must be called with
+// mu held.
+func (stat *Thermostat) reconfiguredChan() chan struct{} {
+	if stat.reconfigured == nil {
+		stat.reconfigured = make(chan struct{}, 1)
+	}
+	return stat.reconfigured
+}
+
 // CurrentTemperatureWindow calculates what the current desired low and high temperatures should be based
 // on the configured modes and schedule.
 func (stat *Thermostat) CurrentTemperatureWindow(t time.Time) *Window {
+	stat.mu.RLock()
+	defer stat.mu.RUnlock()
+	return stat.currentTemperatureWindow(t)
+}
+
+func (stat *Thermostat) currentTemperatureWindow(t time.Time) *Window {
+	return stat.Modes[stat.currentModeName(t)]
+}
+
+// currentModeName returns the name of the mode that applies at t according to the schedule.
+func (stat *Thermostat) currentModeName(t time.Time) string {
+	if stat.Hold.Active(t) {
+		if _, ok := stat.Modes[stat.Hold.Mode]; ok {
+			return stat.Hold.Mode
+		}
+	}
+
 	for _, spec := range stat.Schedule {
 		if _, ok := stat.Modes[spec.ModeName]; !ok {
 			continue
@@ -85,31 +411,29 @@ func (stat *Thermostat) CurrentTemperatureWindow(t time.Time) *Window {
 		case t.Hour() == spec.End.Hour() && t.Minute() > spec.End.Minute():
 			continue
 		default:
-			return stat.Modes[spec.ModeName]
+			return spec.ModeName
 		}
 	}
 
-	return stat.Modes[stat.DefaultMode]
+	return stat.DefaultMode
 }
 
-// ProcessTemperatureReading takes a temperature reading and the units the reading was measured at and determines
-// what commands to send to the HVAC controller to keep the temperature inside of the configured range.
-func (stat *Thermostat) ProcessTemperatureReading(ambientTemp float64, units util.TemperatureUnits) {
-	var temp float64
-	if string(units) == string(util.Celsius) && string(stat.UnitPreference) != string(util.Celsius) {
-		temp = util.TempCToF(ambientTemp)
-	} else if string(units) == string(util.Fahrenheit) && string(stat.UnitPreference) != string(util.Fahrenheit) {
-		temp = util.TempFToC(ambientTemp)
-	} else {
-		temp = ambientTemp
-	}
+// ProcessTemperatureReading takes a temperature reading and determines what commands to send to the HVAC controller to
+// keep the temperature inside of the configured range.
+func (stat *Thermostat) ProcessTemperatureReading(reading util.Temperature) {
+	stat.mu.Lock()
+	previous := stat.control.Direction()
+	defer stat.notifyRunState(previous)
 
-	window := stat.CurrentTemperatureWindow(time.Now())
+	temp := stat.convert(reading)
+	now := time.Now()
+	window := stat.safeWindow(stat.currentTemperatureWindow(now))
+	breach := stat.checkSafety(temp, now)
 
-	log.Printf("Current Temperature (%s): %f, Target: %f to %f", stat.UnitPreference, temp, window.LowTemp, window.HighTemp)
+	log.Printf("Current Temperature (%s): %f, Target: %f to %f", stat.UnitPreference, temp, window.Low.Degrees, window.High.Degrees)
 	switch {
-	case (stat.control.Direction() == controller.Heating && temp > window.LowTemp+stat.Overshoot) /* done heating */ ||
-		(stat.control.Direction() == controller.Cooling && temp < window.HighTemp-stat.Overshoot) /* done cooling */ ||
+	case (stat.control.Direction() == controller.Heating && temp > window.Low.Degrees+stat.Overshoot) /* done heating */ ||
+		(stat.control.Direction() == controller.Cooling && temp < window.High.Degrees-stat.Overshoot) /* done cooling */ ||
 		(time.Duration(stat.MinFan).Nanoseconds() > 0 &&
 			stat.control.Direction() == controller.Fan &&
 			time.Since(stat.LastFan) > 0 &&
@@ -117,11 +441,26 @@ func (stat *Thermostat) ProcessTemperatureReading(ambientTemp float64, units uti
 		log.Println("turning OFF")
 		stat.control.Off()
 		stat.LastFan = time.Now()
-	case temp < window.LowTemp:
+	case breach == controller.Heating && stat.control.Direction() != controller.Heating /* beyond safety limits */ :
+		log.Println("turning on HEAT, below the safety limit")
+		stat.control.Heat()
+		stat.LastFan = time.Now()
+	case breach == controller.Cooling && stat.control.Direction() != controller.Cooling /* beyond safety limits */ :
+		log.Println("turning on COOL, above the safety limit")
+		stat.control.Cool()
+		stat.LastFan = time.Now()
+	case (temp < window.Low.Degrees || temp > window.High.Degrees) &&
+		stat.control.Direction() != controller.Heating && stat.control.Direction() != controller.Cooling &&
+		time.Since(stat.lastOff) < time.Duration(stat.MinOff) /* resting */ :
+		log.Println("waiting for minimum OFF time")
+	case (temp < window.Low.Degrees && stat.faultBlocks(controller.Heating)) ||
+		(temp > window.High.Degrees && stat.faultBlocks(controller.Cooling)) /* cut off by a fault */ :
+		log.Println("not turning on, " + stat.fault.Kind)
+	case temp < window.Low.Degrees:
 		log.Println("turning on HEAT")
 		stat.control.Heat()
 		stat.LastFan = time.Now()
-	case temp > window.HighTemp:
+	case temp > window.High.Degrees:
 		log.Println("turning on COOL")
 		stat.control.Cool()
 		stat.LastFan = time.Now()
@@ -133,59 +472,148 @@ func (stat *Thermostat) ProcessTemperatureReading(ambientTemp float64, units uti
 	default:
 		log.Println("doing NOTHING")
 	}
+	stat.diagnose(temp, time.Now())
+
+	stat.logEvent(StreamReading, &util.EventLog{AmbientTemperature: stat.temperature(temp), RawTemperature: stat.raw, Direction: stat.control.Direction()})
+	stat.raw = nil
+	stat.directionChanged(previous)
+}
+
+// directionChanged records when the HVAC system changed direction.  Must be called with mu held.
+func (stat *Thermostat) directionChanged(previous controller.ThermoDirection) {
+	current := stat.control.Direction()
+	if current == previous {
+		return
+	}
 
-	stat.Events.Add(&util.EventLog{AmbientTemperature: temp, Units: stat.UnitPreference, Direction: stat.control.Direction()})
+	stat.since = time.Now()
+	if previous == controller.Heating || previous == controller.Cooling {
+		stat.lastOff = stat.since
+	}
+	stat.publish(StreamDirection, stat.runState())
 }
 
 // HandleError manages errors received from temperature readings to make sure the system does not stay on in the event of
-// not being able to acquire a temperature reading.
+// not being able to acquire a temperature reading.  Once more than MaxErrors readings failed according to the Failure
+// policy the thermostat is degraded until the next good reading.
 func (stat *Thermostat) HandleError() {
-	stat.errorCount++
+	stat.mu.Lock()
+	previous := stat.control.Direction()
+	defer stat.notifyRunState(previous)
 
-	if stat.errorCount > stat.MaxErrors {
-		stat.control.Off()
-		stat.errorCount = 0
+	now := time.Now()
+	if stat.countError(now) > int(stat.MaxErrors) {
+		stat.fallBack(now)
+		stat.directionChanged(previous)
 	}
 }
 
-// Run starts the main event loop to run the thermostat.
-func (stat *Thermostat) Run(cancel <-chan bool) {
+// Run starts the main event loop to run the thermostat.  It returns once ctx is done.
+func (stat *Thermostat) Run(ctx context.Context) {
 	// we want to do something right away
-	temp, units, err := stat.thermometer.ReadTemperature()
-	if err != nil {
-		log.Println("Error reading Temperature: " + err.Error())
-		stat.Events.Add(&util.EventLog{AmbientTemperature: -1, Units: stat.UnitPreference, Direction: stat.control.Direction()})
-		stat.HandleError()
-	} else {
-		stat.ProcessTemperatureReading(temp, units)
-	}
+	stat.readTemperature()
 
+	stat.mu.Lock()
 	ticker := time.NewTicker(time.Duration(stat.PollInterval))
+	reconfigured := stat.reconfiguredChan()
+	stat.mu.Unlock()
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-ticker.C:
-			temp, units, err := stat.thermometer.ReadTemperature()
-			if err != nil {
-				log.Println("Error reading Temperature: " + err.Error())
-				stat.Events.Add(&util.EventLog{AmbientTemperature: -1, Units: stat.UnitPreference, Direction: stat.control.Direction()})
-				stat.HandleError()
-				continue
-			}
-			stat.ProcessTemperatureReading(temp, units)
-		case <-cancel:
+			stat.readTemperature()
+		case <-reconfigured:
+			stat.mu.RLock()
+			ticker.Reset(time.Duration(stat.PollInterval))
+			stat.mu.RUnlock()
+		case <-ctx.Done():
 			return
 		}
 	}
 }
 
+func (stat *Thermostat) readTemperature() {
+	stat.mu.RLock()
+	primary, secondary := stat.thermometer, stat.secondary
+	stat.mu.RUnlock()
+
+	if reading, ok := stat.read(primary, func() { stat.recovered() }); ok {
+		stat.ProcessTemperatureReading(reading)
+		return
+	}
+	stat.HandleError()
+
+	if secondary == nil || stat.Degraded() == nil {
+		return
+	}
+	reading, ok := stat.read(secondary, func() {
+		stat.secondaryDown = false
+		stat.fallBack(time.Now())
+	})
+	if ok {
+		stat.ProcessTemperatureReading(reading)
+		return
+	}
+
+	stat.mu.Lock()
+	previous := stat.control.Direction()
+	stat.secondaryDown = true
+	stat.fallBack(time.Now())
+	stat.directionChanged(previous)
+	stat.notifyRunState(previous)
+}
+
+// read reads meter and runs the sanity checks, calling accepted with mu held if the reading passes.  Thermometers that
+// correct their readings are checked on what the sensor actually read, so that a glitch is caught before smoothing
+// blends it in, and only correct readings that passed.  Failed readings are logged.
+func (stat *Thermostat) read(meter tmeter.Thermometer, accepted func()) (util.Temperature, bool) {
+	corrector, corrects := meter.(tmeter.RawReader)
+	var reading util.Temperature
+	var err error
+	if corrects {
+		reading, err = corrector.ReadRaw()
+	} else {
+		reading, err = meter.ReadTemperature()
+	}
+
+	stat.mu.Lock()
+	defer stat.mu.Unlock()
+	if err == nil {
+		err = stat.checkReading(reading, time.Now())
+	}
+	if err != nil {
+		log.Println("Error reading Temperature: " + err.Error())
+		stat.logEvent(StreamError, &util.EventLog{AmbientTemperature: stat.temperature(-1), Direction: stat.control.Direction(), Message: err.Error()})
+		return reading, false
+	}
+
+	accepted()
+	stat.raw = nil
+	if corrects {
+		raw := reading
+		log.Printf("Raw Temperature (%s): %f", raw.Units, raw.Degrees)
+		stat.raw = &raw
+		reading = corrector.Correct(raw)
+	}
+	return reading, true
+}
+
 // Validate checks that a thermostat has a valid configuration and returns a string explaining any issues.  An empty string denotes a valid configuration.
 func (stat *Thermostat) Validate() string {
+	stat.mu.RLock()
+	defer stat.mu.RUnlock()
+
 	if _, ok := stat.Modes[stat.DefaultMode]; !ok {
 		return "DefaultMode definition not found!"
 	}
 
+	if !stat.validUnits() {
+		return invalidUnits
+	}
+
 	for key, window := range stat.Modes {
-		if window.LowTemp >= window.HighTemp {
+		if window.Low.Degrees >= window.High.Degrees {
 			return fmt.Sprintf("%s mode is not valid.", key)
 		}
 	}
@@ -198,5 +626,33 @@ func (stat *Thermostat) Validate() string {
 		}
 	}
 
+	if stat.Hold != nil {
+		if _, ok := stat.Modes[stat.Hold.Mode]; !ok {
+			return "Hold mode definition not found!"
+		}
+	}
+
+	if stat.Sanity != nil && ((stat.Sanity.Plausible != nil && stat.Sanity.Plausible.Low.Degrees >= stat.Sanity.Plausible.High.Degrees) ||
+		stat.Sanity.MaxRate < 0 || stat.Sanity.StuckAfter < 0) {
+		return "Sanity checks are not valid."
+	}
+
+	if stat.Safety != nil && stat.Safety.Low.Degrees >= stat.Safety.High.Degrees {
+		return "Safety limits are not valid."
+	}
+
+	if stat.Failure != nil {
+		if stat.Failure.Window < 0 {
+			return "Failure window is not valid."
+		}
+		if protection := stat.Failure.FreezeProtection; protection != nil && (protection.On <= 0 || protection.Period <= protection.On) {
+			return "Freeze protection needs a positive on shorter than its period."
+		}
+	}
+
+	if stat.Diagnostics != nil && (stat.Diagnostics.After <= 0 || stat.Diagnostics.MinChange <= 0) {
+		return "Diagnostics need a positive after and minChange."
+	}
+
 	return ""
 }
@@ -1,38 +1,44 @@
 package thermostat
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/alittlebrighter/thermostat/controller"
+	tmeter "github.com/alittlebrighter/thermostat/thermometer"
 	"github.com/alittlebrighter/thermostat/util"
 )
 
 func TestProcessTemperatureReading(t *testing.T) {
-	baseThermostat.Modes = map[string]*Window{"default": &Window{LowTemp: 69, HighTemp: 80}}
+	baseThermostat.Modes = map[string]*Window{"default": NewWindow(69, 80, "")}
 
 	// order of tests matters because the process takes direction into account and it's unlikely
 	// and probably bad to run the AC directly following the heat
-	baseThermostat.ProcessTemperatureReading(68.9, util.Celsius)
+	baseThermostat.ProcessTemperatureReading(util.Temperature{Degrees: 68.9, Units: util.Celsius})
 	if baseThermostat.control.Direction() != controller.Heating {
 		t.Error("Failed to set direction to HEATING.")
 	}
 
-	baseThermostat.ProcessTemperatureReading(72.5, util.Celsius)
+	baseThermostat.ProcessTemperatureReading(util.Temperature{Degrees: 72.5, Units: util.Celsius})
 	if baseThermostat.control.Direction() != controller.None {
 		t.Error("Failed to set direction to NONE.")
 	}
 
-	baseThermostat.ProcessTemperatureReading(82, util.Celsius)
+	baseThermostat.ProcessTemperatureReading(util.Temperature{Degrees: 82, Units: util.Celsius})
 	if baseThermostat.control.Direction() != controller.Cooling {
 		t.Error("Failed to set direction to COOLING.")
 	}
 }
 
 func TestHandleError(t *testing.T) {
-	baseThermostat.Modes = map[string]*Window{"default": &Window{LowTemp: 69, HighTemp: 80}}
-	baseThermostat.ProcessTemperatureReading(82, util.Celsius)
+	baseThermostat.Modes = map[string]*Window{"default": NewWindow(69, 80, "")}
+	baseThermostat.ProcessTemperatureReading(util.Temperature{Degrees: 82, Units: util.Celsius})
 	baseThermostat.MaxErrors = 3
 
 	for i := 0; uint8(i) < baseThermostat.MaxErrors; i++ {
@@ -48,8 +54,598 @@ func TestHandleError(t *testing.T) {
 	}
 }
 
+func TestConfigureWhileRunning(t *testing.T) {
+	meter := new(MockCountingThermometer)
+	stat := &Thermostat{
+		Modes:          map[string]*Window{"default": NewWindow(69, 80, "")},
+		DefaultMode:    "default",
+		PollInterval:   util.Duration(time.Hour),
+		UnitPreference: util.Celsius,
+		Events:         util.NewRingBuffer(1),
+		control:        new(MockController),
+		thermometer:    meter,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go stat.Run(ctx)
+
+	update := stat.Copy()
+	update.Modes = map[string]*Window{"default": NewWindow(60, 70, "")}
+	update.PollInterval = util.Duration(time.Millisecond)
+	stat.Configure(update)
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&meter.reads) < 5 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if atomic.LoadInt32(&meter.reads) < 5 {
+		t.Error("Ticker was not re-armed with the new PollInterval.")
+	}
+	if stat.CurrentTemperatureWindow(time.Now()).Low.Degrees != 60 {
+		t.Error("Configuration was not applied.")
+	}
+}
+
+func TestRecover(t *testing.T) {
+	stat := &Thermostat{
+		Modes:          map[string]*Window{"default": NewWindow(69, 80, "")},
+		DefaultMode:    "default",
+		MinFan:         util.Duration(5 * time.Minute),
+		MinOff:         util.Duration(time.Hour),
+		UnitPreference: util.Celsius,
+		Events:         util.NewRingBuffer(1),
+		control:        new(MockController),
+	}
+
+	var saved *RunState
+	stat.SetRunStateListener(func(state *RunState) { saved = state })
+
+	stat.Recover(&RunState{State: controller.State{Direction: controller.Heating, Since: time.Now().Add(-time.Minute)}})
+	if stat.Events.GetLast() == nil || stat.Events.GetLast().Message != "unexpected restart" {
+		t.Error("Unexpected restart was not recorded.")
+	}
+
+	stat.ProcessTemperatureReading(util.Temperature{Degrees: 60, Units: util.Celsius})
+	if stat.control.Direction() != controller.None {
+		t.Error("Turned on HEAT before the minimum off time.")
+	}
+
+	stat.Recover(&RunState{
+		State:   controller.State{Direction: controller.Fan, CleanShutdown: true},
+		LastFan: time.Now().Add(time.Minute),
+	})
+	if stat.control.Direction() != controller.Fan {
+		t.Error("Failed to resume FAN duty cycle.")
+	}
+	if saved == nil || saved.Direction != controller.Fan {
+		t.Error("Run state change was not reported.")
+	}
+}
+
+func TestModeResources(t *testing.T) {
+	stat := &Thermostat{
+		Modes:       map[string]*Window{"default": NewWindow(69, 80, "")},
+		DefaultMode: "default",
+	}
+
+	if created, err := stat.SetMode(&Change{Revision: AnyRevision}, "away", NewWindow(60, 85, "")); err != nil || !created {
+		t.Error("Failed to create mode.")
+	}
+	if _, err := stat.SetMode(&Change{Revision: AnyRevision}, "broken", NewWindow(85, 60, "")); err == nil {
+		t.Error("Accepted an invalid mode.")
+	}
+	if _, err := stat.Mode("broken"); err != ErrNotFound {
+		t.Error("Invalid mode was saved.")
+	}
+	if err := stat.DeleteMode(&Change{Revision: AnyRevision}, "default"); err != ErrInUse {
+		t.Error("Deleted the default mode.")
+	}
+	if err := stat.DeleteMode(&Change{Revision: AnyRevision}, "away"); err != nil {
+		t.Error("Failed to delete mode.")
+	}
+}
+
+func TestScheduleResources(t *testing.T) {
+	stat := &Thermostat{
+		Modes:       map[string]*Window{"default": NewWindow(69, 80, "")},
+		DefaultMode: "default",
+	}
+
+	spec := new(ScheduleEvent)
+	err := json.Unmarshal([]byte(`{"days": [1, 2], "mode": "default", "start": "7:00AM", "end": "9:00AM"}`), spec)
+	if err != nil || spec.ID == "" {
+		t.Fatal("Failed to assign an ID to a new schedule entry.")
+	}
+	if err := stat.AddScheduleEvent(&Change{Revision: AnyRevision}, spec); err != nil {
+		t.Fatal("Failed to add schedule entry: " + err.Error())
+	}
+
+	spec.ModeName = "missing"
+	if err := stat.ReplaceScheduleEvent(&Change{Revision: AnyRevision}, spec); err == nil {
+		t.Error("Accepted a schedule entry for a missing mode.")
+	}
+	spec.ID = "missing"
+	if err := stat.ReplaceScheduleEvent(&Change{Revision: AnyRevision}, spec); err != ErrNotFound {
+		t.Error("Replaced a schedule entry that doesn't exist.")
+	}
+}
+
+func TestRevisionConflict(t *testing.T) {
+	stat := &Thermostat{
+		Modes:       map[string]*Window{"default": NewWindow(69, 80, "")},
+		DefaultMode: "default",
+	}
+
+	first := &Change{Author: "parent", Revision: stat.Copy().Revision}
+	second := &Change{Author: "teenager", Revision: first.Revision}
+
+	if _, err := stat.SetMode(first, "default", NewWindow(65, 80, "")); err != nil {
+		t.Fatal("Failed to change mode: " + err.Error())
+	}
+	if first.Revision != stat.Copy().Revision {
+		t.Error("Change was not given the new revision.")
+	}
+	if _, err := stat.SetMode(second, "default", NewWindow(75, 80, "")); err != ErrRevisionMismatch {
+		t.Error("Accepted a change based on an outdated revision.")
+	}
+
+	trail := stat.AuditTrail()
+	if len(trail) != 1 || trail[0].Author != "parent" || trail[0].Revision != first.Revision {
+		t.Error("Change was not recorded in the audit trail.")
+	}
+}
+
+func TestRollBack(t *testing.T) {
+	stat := &Thermostat{
+		Modes:       map[string]*Window{"default": NewWindow(69, 80, "")},
+		DefaultMode: "default",
+	}
+
+	var snapshots []*Thermostat
+	stat.SetChangeListener(func(entry *AuditEntry, snapshot *Thermostat) {
+		snapshots = append(snapshots, snapshot)
+	})
+
+	stat.SetMode(&Change{Revision: AnyRevision}, "default", NewWindow(50, 60, ""))
+	stat.SetMode(&Change{Revision: AnyRevision}, "default", NewWindow(90, 99, ""))
+	if len(snapshots) != 2 {
+		t.Fatal("Changes were not reported to the listener.")
+	}
+
+	if err := stat.RollBack(&Change{Revision: AnyRevision}, snapshots[0]); err != nil {
+		t.Fatal("Failed to roll back: " + err.Error())
+	}
+	if window, _ := stat.Mode("default"); window.Low.Degrees != 50 {
+		t.Error("Configuration was not rolled back.")
+	}
+	if stat.Copy().Revision != 3 {
+		t.Error("Rolling back did not create a new revision.")
+	}
+}
+
+func TestChangeListener(t *testing.T) {
+	stat := &Thermostat{
+		Modes:       map[string]*Window{"default": NewWindow(69, 80, "")},
+		DefaultMode: "default",
+	}
+
+	var mu sync.Mutex
+	var revisions []uint64
+	stat.SetChangeListener(func(entry *AuditEntry, snapshot *Thermostat) {
+		// the thermostat must not be locked while the listener runs, e.g. saving the configuration to disk
+		if copied := stat.Copy(); copied.Revision < entry.Revision {
+			t.Errorf("Listener saw revision %d while reporting %d", copied.Revision, entry.Revision)
+		}
+		mu.Lock()
+		revisions = append(revisions, entry.Revision)
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			stat.SetMode(&Change{Revision: AnyRevision}, "default", NewWindow(float64(50+i), 90, ""))
+		}(i)
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(revisions) != 10 {
+		t.Fatalf("Expected 10 changes reported, got %d", len(revisions))
+	}
+	for i, revision := range revisions {
+		if revision != uint64(i+1) {
+			t.Fatalf("Expected changes reported in order, got %v", revisions)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	stat := &Thermostat{
+		Modes:          map[string]*Window{"default": NewWindow(69, 80, "")},
+		DefaultMode:    "default",
+		UnitPreference: util.Fahrenheit,
+		Diagnostics:    &Diagnostics{After: util.Duration(30 * time.Minute), MinChange: 1, CutOff: true},
+		Events:         util.NewRingBuffer(1),
+		control:        new(MockController),
+	}
+
+	stat.ProcessTemperatureReading(util.Temperature{Degrees: 65, Units: util.Fahrenheit})
+	if stat.control.Direction() != controller.Heating || stat.checkpoint == nil {
+		t.Fatal("Failed to start measuring heating.")
+	}
+
+	stat.checkpoint.time = stat.checkpoint.time.Add(-31 * time.Minute)
+	stat.ProcessTemperatureReading(util.Temperature{Degrees: 65.5, Units: util.Fahrenheit})
+	fault := stat.Fault()
+	if fault == nil || fault.Kind != FaultHeatingIneffective {
+		t.Fatalf("Expected ineffective heating, got %+v", fault)
+	}
+	if stat.control.Direction() != controller.None {
+		t.Error("Failed to cut off ineffective heating.")
+	}
+
+	stat.ProcessTemperatureReading(util.Temperature{Degrees: 64, Units: util.Fahrenheit})
+	if stat.control.Direction() != controller.None {
+		t.Error("Heating restarted before the fault was cleared.")
+	}
+
+	if err := stat.ClearFault(); err != nil {
+		t.Error(err)
+	}
+	stat.ProcessTemperatureReading(util.Temperature{Degrees: 64, Units: util.Fahrenheit})
+	if stat.control.Direction() != controller.Heating {
+		t.Error("Failed to heat again after clearing the fault.")
+	}
+	if stat.ClearFault() != ErrNotFound {
+		t.Error("Cleared a fault that did not exist.")
+	}
+}
+
+func TestSanityChecks(t *testing.T) {
+	stat := &Thermostat{
+		UnitPreference: util.Fahrenheit,
+		Sanity: &SanityChecks{
+			Plausible:  NewWindow(30, 110, ""),
+			MaxRate:    1,
+			StuckAfter: util.Duration(time.Hour),
+		},
+	}
+
+	now := time.Now()
+	if err := stat.checkReading(util.Temperature{Degrees: 20, Units: util.Celsius}, now); err != nil {
+		t.Error("Rejected a sane reading: " + err.Error())
+	}
+	if stat.checkReading(util.Temperature{Degrees: 150, Units: util.Celsius}, now.Add(time.Minute)) == nil {
+		t.Error("Accepted an implausible reading.")
+	}
+	if stat.checkReading(util.Temperature{Degrees: 72, Units: util.Fahrenheit}, now.Add(time.Minute)) == nil {
+		t.Error("Accepted a reading that changed too fast.")
+	}
+	if err := stat.checkReading(util.Temperature{Degrees: 69, Units: util.Fahrenheit}, now.Add(2*time.Minute)); err != nil {
+		t.Error("Rejected a reasonable change: " + err.Error())
+	}
+	if err := stat.checkReading(util.Temperature{Degrees: 69, Units: util.Fahrenheit}, now.Add(time.Hour)); err != nil {
+		t.Error("Considered the thermometer stuck too early: " + err.Error())
+	}
+	if stat.checkReading(util.Temperature{Degrees: 69, Units: util.Fahrenheit}, now.Add(2*time.Hour)) == nil {
+		t.Error("Accepted a stuck reading.")
+	}
+	if (&Thermostat{}).checkReading(util.Temperature{Degrees: -300, Units: util.Celsius}, now) == nil {
+		t.Error("Accepted a reading below absolute zero.")
+	}
+}
+
+func TestFailurePolicy(t *testing.T) {
+	stat := &Thermostat{
+		Modes:          map[string]*Window{"default": NewWindow(69, 80, "")},
+		DefaultMode:    "default",
+		MaxErrors:      2,
+		UnitPreference: util.Celsius,
+		Failure: &FailurePolicy{
+			FreezeProtection: &FreezeProtection{Below: 100, On: util.Duration(10 * time.Minute), Period: util.Duration(time.Hour)},
+		},
+		Events:      util.NewRingBuffer(1),
+		control:     new(MockController),
+		thermometer: new(MockErrorThermometer),
+	}
+
+	for i := 0; i < 2; i++ {
+		stat.readTemperature()
+	}
+	if stat.Degraded() != nil {
+		t.Fatal("Degraded after too few errors.")
+	}
+	stat.thermometer = new(MockThermometer)
+	stat.readTemperature()
+	stat.thermometer = new(MockErrorThermometer)
+	for i := 0; i < 2; i++ {
+		stat.readTemperature()
+	}
+	if stat.Degraded() != nil {
+		t.Fatal("A good reading did not reset the count of failed readings.")
+	}
+
+	secondary := new(MockThermometer)
+	stat.SetSecondaryThermometer(secondary)
+	stat.readTemperature()
+	if degraded := stat.Degraded(); degraded == nil || degraded.Fallback != FallbackSecondary {
+		t.Fatalf("Expected to fall back to the secondary thermometer, got %+v", degraded)
+	}
+
+	stat.secondary = new(MockErrorThermometer)
+	stat.readTemperature()
+	if degraded := stat.Degraded(); degraded == nil || degraded.Fallback != FallbackFreezeProtection {
+		t.Fatalf("Expected to fall back to freeze protection, got %+v", degraded)
+	}
+	if stat.control.Direction() != controller.Heating {
+		t.Error("Freeze protection did not turn on the heat.")
+	}
+
+	stat.thermometer = new(MockThermometer)
+	stat.readTemperature()
+	if stat.Degraded() != nil {
+		t.Error("Still degraded after a good reading.")
+	}
+	if stat.control.Direction() != controller.None {
+		t.Error("Kept heating after recovering.")
+	}
+}
+
+func TestSafetyLimits(t *testing.T) {
+	stat := &Thermostat{
+		Modes:          map[string]*Window{"default": NewWindow(69, 80, ""), "vacation": NewWindow(30, 100, "")},
+		DefaultMode:    "default",
+		Hold:           &Hold{Mode: "vacation"},
+		Overshoot:      2,
+		MinOff:         util.Duration(time.Hour),
+		UnitPreference: util.Fahrenheit,
+		Safety:         NewWindow(45, 90, ""),
+		Events:         util.NewRingBuffer(1),
+		control:        new(MockController),
+	}
+	stat.lastOff = time.Now()
+
+	stat.ProcessTemperatureReading(util.Temperature{Degrees: 50, Units: util.Fahrenheit})
+	if stat.control.Direction() != controller.None {
+		t.Error("Heated within the safety limits.")
+	}
+
+	stat.ProcessTemperatureReading(util.Temperature{Degrees: 44, Units: util.Fahrenheit})
+	if stat.control.Direction() != controller.Heating {
+		t.Error("Failed to heat below the safety limit while resting.")
+	}
+	if breach := stat.Status().SafetyBreach; breach == nil || breach.Limit != 45 {
+		t.Errorf("Expected a breach of the low limit, got %+v", breach)
+	}
+
+	stat.ProcessTemperatureReading(util.Temperature{Degrees: 46, Units: util.Fahrenheit})
+	if stat.control.Direction() != controller.Heating {
+		t.Error("Stopped heating before getting well within the safety limits.")
+	}
+	if stat.Status().SafetyBreach != nil {
+		t.Error("Breach not over after getting back within the limits.")
+	}
+
+	stat.ProcessTemperatureReading(util.Temperature{Degrees: 47.5, Units: util.Fahrenheit})
+	if stat.control.Direction() != controller.None {
+		t.Error("Failed to stop heating past the safety limit plus overshoot.")
+	}
+}
+
+func TestSafetyLimitsBeyondMode(t *testing.T) {
+	stat := &Thermostat{
+		Modes:          map[string]*Window{"default": NewWindow(69, 80, ""), "vacation": NewWindow(35, 40, ""), "sauna": NewWindow(95, 100, "")},
+		DefaultMode:    "default",
+		Hold:           &Hold{Mode: "vacation"},
+		Overshoot:      2,
+		UnitPreference: util.Fahrenheit,
+		Safety:         NewWindow(45, 90, ""),
+		Events:         util.NewRingBuffer(1),
+		control:        new(MockController),
+	}
+
+	// a mode below the low limit keeps heating around it and never cools
+	for _, step := range []struct {
+		temp      float64
+		direction controller.ThermoDirection
+	}{{44, controller.Heating}, {46, controller.Heating}, {47.5, controller.None}, {46, controller.None}, {44.5, controller.Heating}} {
+		stat.ProcessTemperatureReading(util.Temperature{Degrees: step.temp, Units: util.Fahrenheit})
+		if stat.control.Direction() != step.direction {
+			t.Errorf("Below the low limit at %v expected %s, got %s", step.temp, step.direction, stat.control.Direction())
+		}
+	}
+	if window := stat.safeWindow(stat.Modes["vacation"]); window.Low.Degrees != 45 || window.High.Degrees != 50 {
+		t.Errorf("Expected the vacation window moved to 45-50, got %+v", window)
+	}
+
+	// and a mode above the high limit keeps cooling around it and never heats
+	stat.Hold = &Hold{Mode: "sauna"}
+	stat.control.Off()
+	for _, step := range []struct {
+		temp      float64
+		direction controller.ThermoDirection
+	}{{91, controller.Cooling}, {89, controller.Cooling}, {87.5, controller.None}, {89, controller.None}, {90.5, controller.Cooling}} {
+		stat.ProcessTemperatureReading(util.Temperature{Degrees: step.temp, Units: util.Fahrenheit})
+		if stat.control.Direction() != step.direction {
+			t.Errorf("Above the high limit at %v expected %s, got %s", step.temp, step.direction, stat.control.Direction())
+		}
+	}
+	if window := stat.safeWindow(stat.Modes["sauna"]); window.Low.Degrees != 85 || window.High.Degrees != 90 {
+		t.Errorf("Expected the sauna window moved to 85-90, got %+v", window)
+	}
+}
+
+func TestCalibratedReadings(t *testing.T) {
+	meter, err := tmeter.NewCalibrated(new(MockThermometer), &tmeter.CalibrationConfig{Offset: -1.5}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := &Thermostat{
+		Modes:          map[string]*Window{"default": NewWindow(60, 80, "")},
+		DefaultMode:    "default",
+		UnitPreference: util.Celsius,
+		Events:         util.NewRingBuffer(1),
+		control:        new(MockController),
+		thermometer:    meter,
+	}
+
+	stat.readTemperature()
+	last := stat.Events.GetLast()
+	if last.AmbientTemperature.Degrees != ambientTemp-1.5 || last.RawTemperature == nil || last.RawTemperature.Degrees != ambientTemp {
+		t.Errorf("Expected the calibrated reading logged along with the raw one, got %+v", last)
+	}
+}
+
+func TestSpikeBeforeSmoothing(t *testing.T) {
+	sensor := &MockScriptedThermometer{temps: []float64{20, 20, 30, 20}}
+	meter, err := tmeter.NewCalibrated(sensor, nil, &tmeter.SmoothingConfig{Filter: tmeter.FilterAverage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := &Thermostat{
+		UnitPreference: util.Celsius,
+		Sanity:         &SanityChecks{Plausible: NewWindow(0, 40, ""), MaxRate: 1},
+		Events:         util.NewRingBuffer(1),
+		control:        new(MockController),
+	}
+
+	for i, expected := range []float64{20, 20, -1, 20} {
+		reading, ok := stat.read(meter, func() {})
+		switch {
+		case expected < 0 && ok:
+			t.Errorf("Reading %d: accepted the spike to %v", i, reading.Degrees)
+		case expected >= 0 && (!ok || reading.Degrees != expected):
+			t.Errorf("Reading %d: expected %v unaffected by the spike, got %v (accepted %v)", i, expected, reading.Degrees, ok)
+		}
+	}
+}
+
+func TestUnitPreferenceChange(t *testing.T) {
+	stat := new(Thermostat)
+	stat.Configure(&Thermostat{
+		Modes:          map[string]*Window{"default": NewWindow(69, 80, "")},
+		DefaultMode:    "default",
+		Overshoot:      1.8,
+		UnitPreference: util.Fahrenheit,
+		Safety:         NewWindow(7, 32, util.Celsius),
+	})
+	if safety := stat.Copy().Safety; safety.Units() != util.Fahrenheit || math.Abs(safety.Low.Degrees-44.6) > 1e-9 {
+		t.Errorf("Expected the safety limits converted to Fahrenheit, got %+v", safety)
+	}
+
+	// a client changing only the preference of the configuration it read
+	data, _ := json.Marshal(stat)
+	update := new(Thermostat)
+	if err := json.Unmarshal(data, update); err != nil {
+		t.Fatal(err)
+	}
+	update.UnitPreference = util.Celsius
+	if msg := update.Validate(); msg != "" {
+		t.Fatal(msg)
+	}
+	if err := stat.Replace(&Change{Revision: AnyRevision}, update); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := stat.Copy()
+	if window := snapshot.Modes["default"]; window.Units() != util.Celsius || math.Abs(window.Low.Degrees-20.5556) > 1e-3 || math.Abs(window.High.Degrees-26.6667) > 1e-3 {
+		t.Errorf("Expected the mode converted to Celsius, got %+v", window)
+	}
+	if math.Abs(snapshot.Overshoot-1) > 1e-9 || math.Abs(snapshot.Safety.High.Degrees-32) > 1e-9 {
+		t.Errorf("Expected the overshoot and safety limits converted, got %v and %+v", snapshot.Overshoot, snapshot.Safety)
+	}
+
+	if _, err := stat.SetMode(&Change{Revision: AnyRevision}, "away", NewWindow(283.15, 303.15, util.Kelvin)); err != nil {
+		t.Fatal(err)
+	}
+	if window, _ := stat.Mode("away"); math.Abs(window.Low.Degrees-10) > 1e-9 || window.Units() != util.Celsius {
+		t.Errorf("Expected the mode converted from Kelvin, got %+v", window)
+	}
+	if _, err := stat.SetMode(&Change{Revision: AnyRevision}, "away", NewWindow(10, 20, "celsius")); err == nil {
+		t.Error("Accepted unknown units.")
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	stat := new(Thermostat)
+	data := `{"defaultMode": "default", "unitPreference": "Fahrenheit", "units": "Celsius", "overshoot": 1,
+		"modes": {"default": {"low": 20, "high": 24}, "night": {"low": 60, "high": 77, "units": "Fahrenheit"}},
+		"safety": {"low": 45, "high": 95, "units": "Fahrenheit"}}`
+	if err := json.Unmarshal([]byte(data), stat); err != nil {
+		t.Fatal(err)
+	}
+	stat.Events = util.NewRingBuffer(1)
+	stat.control = new(MockController)
+
+	if err := stat.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if window := stat.Modes["default"]; window.Units() != util.Fahrenheit || math.Abs(window.Low.Degrees-68) > 1e-9 || math.Abs(window.High.Degrees-75.2) > 1e-9 {
+		t.Errorf("Expected the default mode in Fahrenheit, got %+v", window)
+	}
+	if window := stat.Modes["night"]; window.Low.Degrees != 60 || window.High.Degrees != 77 {
+		t.Errorf("Expected the night mode kept in Fahrenheit, got %+v", window)
+	}
+	if stat.Safety.Low.Degrees != 45 || stat.Safety.High.Degrees != 95 || math.Abs(stat.Overshoot-1.8) > 1e-9 {
+		t.Errorf("Expected the safety limits and overshoot in Fahrenheit, got %+v and %v", stat.Safety, stat.Overshoot)
+	}
+
+	stat.ProcessTemperatureReading(util.Temperature{Degrees: 21, Units: util.Celsius})
+	if direction := stat.control.Direction(); direction != controller.None {
+		t.Errorf("Expected 21°C to be within 20-24°C, the HVAC system is %s", direction)
+	}
+
+	invalid := &Thermostat{DefaultMode: "default", UnitPreference: util.Celsius, Modes: Modes{"default": NewWindow(68, 75, "Rankine")}}
+	if invalid.Normalize() == nil {
+		t.Error("Accepted unknown units.")
+	}
+}
+
+func TestWindowJSON(t *testing.T) {
+	for data, expected := range map[string]*Window{
+		`{"low": 20, "high": 25}`:                            NewWindow(20, 25, ""),
+		`{"low": "68°F", "high": "77 f"}`:                    NewWindow(68, 77, util.Fahrenheit),
+		`{"low": "20°C", "high": "77F", "units": "celsius"}`: NewWindow(20, 25, util.Celsius),
+		`{"low": 293.15, "high": "25C", "units": "K"}`:       NewWindow(293.15, 298.15, util.Kelvin),
+	} {
+		window := new(Window)
+		if err := json.Unmarshal([]byte(data), window); err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(window.Low.Degrees-expected.Low.Degrees) > 1e-9 || math.Abs(window.High.Degrees-expected.High.Degrees) > 1e-9 ||
+			window.Low.Units != expected.Units() || window.High.Units != expected.Units() {
+			t.Errorf("Expected %s to be %+v, got %+v", data, expected, window)
+		}
+	}
+
+	// windows go out as numbers, as clients expect, and come back the same
+	data, _ := json.Marshal(Modes{"away": NewWindow(10, 30.5, util.Celsius), "default": NewWindow(69, 80, "")})
+	if string(data) != `{"away":{"low":10,"high":30.5,"units":"Celsius"},"default":{"low":69,"high":80}}` {
+		t.Errorf("Unexpected JSON %s", data)
+	}
+	modes := Modes{}
+	if err := json.Unmarshal(data, &modes); err != nil {
+		t.Fatal(err)
+	}
+	if *modes["away"] != *NewWindow(10, 30.5, util.Celsius) || *modes["default"] != *NewWindow(69, 80, "") {
+		t.Errorf("Windows changed in a round trip: %+v and %+v", modes["away"], modes["default"])
+	}
+
+	for _, data := range []string{`{"low": "20°X", "high": 25}`, `{"low": "warm", "high": 25}`, `{"low": 20, "high": 25, "units": "R"}`} {
+		if err := json.Unmarshal([]byte(data), new(Window)); err == nil {
+			t.Errorf("Accepted %s", data)
+		}
+	}
+}
+
 var baseThermostat = &Thermostat{
-	Modes:          map[string]*Window{"default": &Window{LowTemp: 69, HighTemp: 80}},
+	Modes:          map[string]*Window{"default": NewWindow(69, 80, "")},
 	DefaultMode:    "default",
 	Schedule:       []*ScheduleEvent{},
 	Overshoot:      3,
@@ -88,18 +684,42 @@ func (mc *MockController) Shutdown() {}
 
 type MockThermometer struct{}
 
-func (mt *MockThermometer) ReadTemperature() (float64, util.TemperatureUnits, error) {
-	return ambientTemp, util.Celsius, nil
+func (mt *MockThermometer) ReadTemperature() (util.Temperature, error) {
+	return util.Temperature{Degrees: ambientTemp, Units: util.Celsius}, nil
 }
 
 func (mt *MockThermometer) Shutdown() {}
 
 type MockErrorThermometer struct{}
 
-func (mt *MockErrorThermometer) ReadTemperature() (float64, util.TemperatureUnits, error) {
-	return ambientTemp, util.Celsius, errors.New("Temperature not available.")
+func (mt *MockErrorThermometer) ReadTemperature() (util.Temperature, error) {
+	return util.Temperature{}, errors.New("Temperature not available.")
 }
 
 func (mt *MockErrorThermometer) Shutdown() {}
 
+type MockCountingThermometer struct {
+	reads int32
+}
+
+func (mt *MockCountingThermometer) ReadTemperature() (util.Temperature, error) {
+	atomic.AddInt32(&mt.reads, 1)
+	return util.Temperature{Degrees: ambientTemp, Units: util.Celsius}, nil
+}
+
+func (mt *MockCountingThermometer) Shutdown() {}
+
+// MockScriptedThermometer reads temps in turn, in Celsius.
+type MockScriptedThermometer struct {
+	temps []float64
+}
+
+func (mt *MockScriptedThermometer) ReadTemperature() (util.Temperature, error) {
+	temp := mt.temps[0]
+	mt.temps = mt.temps[1:]
+	return util.Temperature{Degrees: temp, Units: util.Celsius}, nil
+}
+
+func (mt *MockScriptedThermometer) Shutdown() {}
+
 var ambientTemp = 72.5
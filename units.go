@@ -0,0 +1,181 @@
+package thermostat
+
+import (
+	"encoding/json"
+
+	"github.com/alittlebrighter/thermostat/util"
+)
+
+const invalidUnits = "Temperature units must be Celsius, Fahrenheit or Kelvin."
+
+// NewWindow returns a window from low to high degrees of units, which may be empty for those of the configuration.
+func NewWindow(low, high float64, units util.TemperatureUnits) *Window {
+	return &Window{Low: util.Temperature{Degrees: low, Units: units}, High: util.Temperature{Degrees: high, Units: units}}
+}
+
+// Units returns what the window's temperatures are in, empty if it doesn't say.
+func (w *Window) Units() util.TemperatureUnits {
+	return w.Low.Units
+}
+
+// In returns a copy of the window in units.
+func (w *Window) In(units util.TemperatureUnits) *Window {
+	return w.in(w.Units(), units)
+}
+
+// in returns a copy of the window in units to.  Windows that don't say what units they are in are taken to be in from.
+func (w *Window) in(from, to util.TemperatureUnits) *Window {
+	if w == nil {
+		return nil
+	}
+	if w.Units() != "" {
+		from = w.Units()
+	}
+	return NewWindow(util.ConvertTemperature(w.Low.Degrees, from, to), util.ConvertTemperature(w.High.Degrees, from, to), to)
+}
+
+// windowJSON is how a Window is marshaled.
+type windowJSON struct {
+	Low   util.Temperature      `json:"low"`
+	High  util.Temperature      `json:"high"`
+	Units util.TemperatureUnits `json:"units,omitempty"`
+}
+
+// MarshalJSON gives low and high as numbers, with the units they are in if the window says.
+func (w Window) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&windowJSON{
+		Low:   util.Temperature{Degrees: w.Low.Degrees},
+		High:  util.Temperature{Degrees: w.High.Degrees},
+		Units: w.Units(),
+	})
+}
+
+// UnmarshalJSON takes low and high as numbers or as temperatures with units, e.g. "20.5°C", which are converted to the
+// window's units.  A window that doesn't say what units it is in takes those of its temperatures.
+func (w *Window) UnmarshalJSON(data []byte) error {
+	window := new(windowJSON)
+	if err := json.Unmarshal(data, window); err != nil {
+		return err
+	}
+
+	units := window.Units
+	for _, t := range []util.Temperature{window.Low, window.High} {
+		if units == "" {
+			units = t.Units
+		}
+	}
+	degrees := func(t util.Temperature) float64 {
+		if t.Units == "" {
+			return t.Degrees
+		}
+		return t.In(units).Degrees
+	}
+	*w = *NewWindow(degrees(window.Low), degrees(window.High), units)
+	return nil
+}
+
+// Normalize converts the temperatures of a configuration loaded from a file to UnitPreference, as applying it through
+// the API does.  It must be called before the thermostat is run.
+func (stat *Thermostat) Normalize() error {
+	stat.mu.Lock()
+	defer stat.mu.Unlock()
+
+	if !stat.validUnits() {
+		return InvalidError(invalidUnits)
+	}
+	stat.setTemperatures(stat.copy())
+	return nil
+}
+
+// temperatureUnits returns the units the temperatures of a configuration are given in.
+func (stat *Thermostat) temperatureUnits() util.TemperatureUnits {
+	if stat.Units != "" {
+		return stat.Units
+	}
+	return stat.UnitPreference
+}
+
+// setTemperatures sets the temperature settings of stat to those of update, converted from the units update gives
+// them in to stat's UnitPreference.  Settings are copied rather than shared with update.
+func (stat *Thermostat) setTemperatures(update *Thermostat) {
+	from, to := update.temperatureUnits(), stat.UnitPreference
+
+	stat.Modes = make(Modes, len(update.Modes))
+	for name, window := range update.Modes {
+		stat.Modes[name] = window.in(from, to)
+	}
+	stat.Overshoot = util.ConvertDifference(update.Overshoot, from, to)
+	stat.Safety = update.Safety.in(from, to)
+
+	stat.Sanity = nil
+	if update.Sanity != nil {
+		sanity := *update.Sanity
+		sanity.Plausible = sanity.Plausible.in(from, to)
+		sanity.MaxRate = util.ConvertDifference(sanity.MaxRate, from, to)
+		stat.Sanity = &sanity
+	}
+
+	stat.Diagnostics = nil
+	if update.Diagnostics != nil {
+		diagnostics := *update.Diagnostics
+		diagnostics.MinChange = util.ConvertDifference(diagnostics.MinChange, from, to)
+		stat.Diagnostics = &diagnostics
+	}
+
+	stat.Failure = nil
+	if update.Failure != nil {
+		failure := *update.Failure
+		if failure.FreezeProtection != nil {
+			protection := *failure.FreezeProtection
+			protection.Below = util.ConvertTemperature(protection.Below, from, to)
+			failure.FreezeProtection = &protection
+		}
+		stat.Failure = &failure
+	}
+
+	stat.Units = to
+}
+
+// unitsChanged converts what the thermostat remembers of past readings when the UnitPreference changes from from.
+// Must be called with mu held.
+func (stat *Thermostat) unitsChanged(from util.TemperatureUnits) {
+	to := stat.UnitPreference
+	for _, s := range []*sample{stat.lastValue, stat.lastAccepted} {
+		if s != nil {
+			s.temp = util.ConvertTemperature(s.temp, from, to)
+		}
+	}
+	if stat.checkpoint != nil {
+		stat.checkpoint.temp = util.ConvertTemperature(stat.checkpoint.temp, from, to)
+	}
+	if stat.breach != nil {
+		breach := *stat.breach
+		breach.Limit = util.ConvertTemperature(breach.Limit, from, to)
+		breach.Temperature = util.ConvertTemperature(breach.Temperature, from, to)
+		stat.breach = &breach
+	}
+}
+
+// validUnits checks the units of a configuration and of its windows, where they are set.
+func (stat *Thermostat) validUnits() bool {
+	units := []util.TemperatureUnits{stat.UnitPreference, stat.Units}
+	windows := []*Window{stat.Safety}
+	for _, window := range stat.Modes {
+		windows = append(windows, window)
+	}
+	if stat.Sanity != nil {
+		windows = append(windows, stat.Sanity.Plausible)
+	}
+	for _, window := range windows {
+		if window != nil {
+			units = append(units, window.Low.Units, window.High.Units)
+		}
+	}
+
+	for _, u := range units {
+		if u != "" && !u.Valid() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,33 @@
+package util
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// ErrorResponse is the body of every error returned by the HTTP APIs.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// WriteJSON responds with v encoded as JSON.
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("ERROR: " + err.Error())
+	}
+}
+
+// WriteError responds with msg wrapped in an ErrorResponse.
+func WriteError(w http.ResponseWriter, status int, msg string) {
+	log.Println("ERROR: " + msg)
+	WriteJSON(w, status, &ErrorResponse{Error: msg})
+}
+
+// MethodNotAllowed responds with 405 and the list of allowed methods.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request, allowed string) {
+	w.Header().Set("Allow", allowed)
+	WriteError(w, http.StatusMethodNotAllowed, "method "+r.Method+" not allowed")
+}
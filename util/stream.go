@@ -0,0 +1,108 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// StreamEvent is something that happened on the thermostat, numbered so that clients can resume after reconnecting.
+type StreamEvent struct {
+	ID   uint64      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// Stream fans StreamEvents out to subscribers and keeps the most recent ones for subscribers that reconnect.  It is safe
+// for concurrent use.
+type Stream struct {
+	mu          sync.Mutex
+	lastID      uint64
+	recent      []*StreamEvent
+	keep        int
+	subscribers map[chan *StreamEvent]struct{}
+}
+
+// subscriberBuffer is how many events a subscriber can fall behind before it is dropped.
+const subscriberBuffer = 64
+
+const (
+	// StreamLive subscribes to the events published from now on, without any that were missed.
+	StreamLive = ^uint64(0)
+	// StreamReset is the type of the event that tells a subscriber the events after its last ID can't be replayed,
+	// because they were published before a restart or are no longer remembered, so it should reload the state.
+	StreamReset = "reset"
+)
+
+// NewStream creates a Stream that remembers the last keep events.  IDs start from the time the stream was created,
+// in units of 1/1024 ms, so that they keep increasing across restarts and stay exact in JavaScript.
+func NewStream(keep int) *Stream {
+	epoch := uint64(time.Now().UnixNano()/int64(time.Millisecond)) << 10
+	return &Stream{lastID: epoch, keep: keep, subscribers: make(map[chan *StreamEvent]struct{})}
+}
+
+// Publish sends an event of eventType to every subscriber without blocking.  Subscribers that have fallen too far
+// behind are dropped and have to resubscribe.
+func (s *Stream) Publish(eventType string, data interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	event := &StreamEvent{ID: s.lastID, Type: eventType, Time: time.Now(), Data: data}
+
+	s.recent = append(s.recent, event)
+	if len(s.recent) > s.keep {
+		s.recent = s.recent[len(s.recent)-s.keep:]
+	}
+
+	for events := range s.subscribers {
+		select {
+		case events <- event:
+		default:
+			delete(s.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// Subscribe returns the remembered events after lastID and a channel receiving every event published from now on.
+// A lastID of 0 gets every remembered event and StreamLive none.  Missed events that can't be replayed are preceded by
+// a StreamReset event.  The channel is closed when the subscriber is dropped or unsubscribe is called.
+func (s *Stream) Subscribe(lastID uint64) (missed []*StreamEvent, events <-chan *StreamEvent, unsubscribe func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if lastID != StreamLive {
+		for _, event := range s.recent {
+			if event.ID > lastID {
+				missed = append(missed, event)
+			}
+		}
+		if lastID != 0 && !s.known(lastID) {
+			resumeAt := s.lastID - uint64(len(missed))
+			missed = append([]*StreamEvent{{ID: resumeAt, Type: StreamReset, Time: time.Now()}}, missed...)
+		}
+	}
+
+	ch := make(chan *StreamEvent, subscriberBuffer)
+	s.subscribers[ch] = struct{}{}
+
+	return missed, ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if _, ok := s.subscribers[ch]; ok {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// known reports whether the events after lastID can all be replayed.  Must be called with mu held.
+func (s *Stream) known(lastID uint64) bool {
+	oldest := s.lastID
+	if len(s.recent) > 0 {
+		oldest = s.recent[0].ID - 1
+	}
+	return lastID >= oldest && lastID <= s.lastID
+}
@@ -0,0 +1,83 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStreamResume(t *testing.T) {
+	stream := NewStream(2)
+	stream.Publish("reading", 1)
+	stream.Publish("reading", 2)
+	stream.Publish("reading", 3)
+
+	all, _, unsubscribe := stream.Subscribe(0)
+	unsubscribe()
+	if len(all) != 2 || all[1].ID != all[0].ID+1 {
+		t.Fatal("Failed to replay the remembered events.")
+	}
+
+	missed, events, unsubscribe := stream.Subscribe(all[0].ID)
+	defer unsubscribe()
+	if len(missed) != 1 || missed[0].ID != all[1].ID {
+		t.Error("Failed to replay the events after the last ID.")
+	}
+
+	stream.Publish("config", 4)
+	if event := <-events; event.ID != all[1].ID+1 || event.Type != "config" {
+		t.Error("Failed to receive a live event.")
+	}
+
+	if missed, _, unsubscribe := stream.Subscribe(StreamLive); len(missed) != 0 {
+		t.Error("Replayed events to a live subscriber.")
+	} else {
+		unsubscribe()
+	}
+}
+
+func TestStreamReset(t *testing.T) {
+	before := NewStream(2)
+	before.Publish("reading", 1)
+	last, _, unsubscribe := before.Subscribe(0)
+	unsubscribe()
+
+	// the process restarted
+	time.Sleep(2 * time.Millisecond)
+	stream := NewStream(2)
+	stream.Publish("reading", 2)
+	missed, _, unsubscribe := stream.Subscribe(last[0].ID)
+	unsubscribe()
+	if len(missed) != 2 || missed[0].Type != StreamReset || missed[1].Data != 2 {
+		t.Fatalf("Expected a reset before the events since the restart, got %+v", missed)
+	}
+	if missed[0].ID != missed[1].ID-1 || missed[1].ID <= last[0].ID {
+		t.Errorf("Expected IDs to keep increasing across restarts, got %d after %d", missed[1].ID, last[0].ID)
+	}
+
+	// events that are no longer remembered
+	for i := 3; i <= 5; i++ {
+		stream.Publish("reading", i)
+	}
+	if missed, _, unsubscribe = stream.Subscribe(missed[1].ID); len(missed) != 3 || missed[0].Type != StreamReset {
+		t.Errorf("Expected a reset when events were forgotten, got %+v", missed)
+	}
+	unsubscribe()
+}
+
+func TestStreamDropsSlowSubscribers(t *testing.T) {
+	stream := NewStream(1)
+	_, events, unsubscribe := stream.Subscribe(0)
+	defer unsubscribe()
+
+	for i := 0; i <= subscriberBuffer; i++ {
+		stream.Publish("reading", i)
+	}
+
+	received := 0
+	for range events {
+		received++
+	}
+	if received != subscriberBuffer {
+		t.Errorf("Expected %d buffered events before being dropped, got %d.", subscriberBuffer, received)
+	}
+}
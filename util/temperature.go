@@ -0,0 +1,191 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TemperatureUnits are the units a temperature is measured in.  Units are parsed leniently from JSON and YAML, e.g.
+// "°C", "c" or "celsius", but always marshaled by their full name.
+type TemperatureUnits string
+
+const (
+	Celsius    TemperatureUnits = "Celsius"
+	Fahrenheit TemperatureUnits = "Fahrenheit"
+	Kelvin     TemperatureUnits = "Kelvin"
+)
+
+// absoluteZero is 0 Kelvin in Celsius.
+const absoluteZero = -273.15
+
+// ParseTemperatureUnits parses units by their name or symbol, ignoring case and a leading degree sign.
+func ParseTemperatureUnits(s string) (TemperatureUnits, error) {
+	switch strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "°") {
+	case "c", "celsius":
+		return Celsius, nil
+	case "f", "fahrenheit":
+		return Fahrenheit, nil
+	case "k", "kelvin":
+		return Kelvin, nil
+	}
+	return "", fmt.Errorf("unknown temperature units %q", s)
+}
+
+// Valid reports whether units are ones the thermostat can convert between.
+func (units TemperatureUnits) Valid() bool {
+	return units == Celsius || units == Fahrenheit || units == Kelvin
+}
+
+// Symbol returns the units' short form, e.g. °C.
+func (units TemperatureUnits) Symbol() string {
+	switch units {
+	case Celsius:
+		return "°C"
+	case Fahrenheit:
+		return "°F"
+	case Kelvin:
+		return "K"
+	}
+	return string(units)
+}
+
+// UnmarshalJSON accepts any form ParseTemperatureUnits does, or an empty string for units that aren't set.
+func (units *TemperatureUnits) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s == "" {
+		*units = ""
+		return nil
+	}
+
+	parsed, err := ParseTemperatureUnits(s)
+	if err != nil {
+		return err
+	}
+	*units = parsed
+	return nil
+}
+
+// ConvertTemperature converts temp from one unit to another.
+func ConvertTemperature(temp float64, from, to TemperatureUnits) float64 {
+	if from == to {
+		return temp
+	}
+
+	celsius := temp
+	switch from {
+	case Fahrenheit:
+		celsius = TempFToC(temp)
+	case Kelvin:
+		celsius = temp + absoluteZero
+	}
+
+	switch to {
+	case Fahrenheit:
+		return TempCToF(celsius)
+	case Kelvin:
+		return celsius - absoluteZero
+	}
+	return celsius
+}
+
+// ConvertDifference converts a difference between two temperatures, e.g. an overshoot, from one unit to another.
+func ConvertDifference(difference float64, from, to TemperatureUnits) float64 {
+	switch {
+	case from == to:
+	case from == Fahrenheit:
+		return difference * 5 / 9
+	case to == Fahrenheit:
+		return difference * 9 / 5
+	}
+	return difference
+}
+
+// TempCToF converts temperature degrees from Celsius to Fahrenheit
+func TempCToF(tempC float64) float64 {
+	return tempC*9/5 + 32
+}
+
+// TempFToC converts temperature degrees from Fahrenheit to Celsius
+func TempFToC(tempF float64) float64 {
+	return (tempF - 32) * 5 / 9
+}
+
+// Temperature is a temperature along with the units it is in.  It marshals to JSON and YAML as a string like
+// "21.5°C", or a bare number if it has no units, and unmarshals from either.
+type Temperature struct {
+	Degrees float64
+	Units   TemperatureUnits
+}
+
+// ParseTemperature parses a number of degrees optionally followed by units, e.g. "21.5", "70F" or "293.15 K".
+func ParseTemperature(s string) (Temperature, error) {
+	s = strings.TrimSpace(s)
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return !strings.ContainsRune("+-.0123456789", r)
+	})
+	if end < 0 {
+		end = len(s)
+	}
+
+	var t Temperature
+	var err error
+	if t.Degrees, err = strconv.ParseFloat(s[:end], 64); err != nil {
+		return Temperature{}, fmt.Errorf("invalid temperature %q", s)
+	}
+	if units := strings.TrimSpace(s[end:]); units != "" {
+		if t.Units, err = ParseTemperatureUnits(units); err != nil {
+			return Temperature{}, err
+		}
+	}
+	return t, nil
+}
+
+// In returns the temperature converted to units.
+func (t Temperature) In(units TemperatureUnits) Temperature {
+	return Temperature{Degrees: ConvertTemperature(t.Degrees, t.Units, units), Units: units}
+}
+
+// Celsius returns the temperature in degrees Celsius.
+func (t Temperature) Celsius() float64 {
+	return t.In(Celsius).Degrees
+}
+
+// Valid reports whether the temperature has known units and isn't below absolute zero.
+func (t Temperature) Valid() bool {
+	return t.Units.Valid() && t.Celsius() >= absoluteZero
+}
+
+func (t Temperature) String() string {
+	return strconv.FormatFloat(t.Degrees, 'f', -1, 64) + t.Units.Symbol()
+}
+
+func (t Temperature) MarshalJSON() ([]byte, error) {
+	if t.Units == "" {
+		return json.Marshal(t.Degrees)
+	}
+	return json.Marshal(t.String())
+}
+
+func (t *Temperature) UnmarshalJSON(data []byte) error {
+	var degrees float64
+	if err := json.Unmarshal(data, &degrees); err == nil {
+		*t = Temperature{Degrees: degrees}
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("temperature must be a number or a string like \"21.5°C\": %s", err.Error())
+	}
+	parsed, err := ParseTemperature(s)
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
@@ -0,0 +1,88 @@
+package util
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestConvertTemperature(t *testing.T) {
+	for _, test := range []struct {
+		temp     float64
+		from, to TemperatureUnits
+		expected float64
+	}{
+		{68, Fahrenheit, Celsius, 20},
+		{20, Celsius, Fahrenheit, 68},
+		{0, Celsius, Kelvin, 273.15},
+		{273.15, Kelvin, Fahrenheit, 32},
+		{212, Fahrenheit, Kelvin, 373.15},
+		{21.5, Celsius, Celsius, 21.5},
+	} {
+		if converted := ConvertTemperature(test.temp, test.from, test.to); math.Abs(converted-test.expected) > 1e-9 {
+			t.Errorf("Expected %v %s to be %v %s, got %v", test.temp, test.from, test.expected, test.to, converted)
+		}
+	}
+
+	if difference := ConvertDifference(9, Fahrenheit, Kelvin); difference != 5 {
+		t.Errorf("Expected 9°F apart to be 5K apart, got %v", difference)
+	}
+	if difference := ConvertDifference(5, Kelvin, Celsius); difference != 5 {
+		t.Errorf("Expected 5K apart to be 5°C apart, got %v", difference)
+	}
+}
+
+func TestParseTemperature(t *testing.T) {
+	for s, expected := range map[string]Temperature{
+		"21.5":            {Degrees: 21.5},
+		"70F":             {Degrees: 70, Units: Fahrenheit},
+		"-3.5 °c":         {Degrees: -3.5, Units: Celsius},
+		"293.15 K":        {Degrees: 293.15, Units: Kelvin},
+		" 68 FAHRENHEIT ": {Degrees: 68, Units: Fahrenheit},
+	} {
+		if parsed, err := ParseTemperature(s); err != nil || parsed != expected {
+			t.Errorf("Expected %q to be %+v, got %+v, %v", s, expected, parsed, err)
+		}
+	}
+	for _, s := range []string{"", "F", "warm", "20 degrees", "20°R"} {
+		if _, err := ParseTemperature(s); err == nil {
+			t.Errorf("Parsed %q", s)
+		}
+	}
+
+	if (Temperature{Degrees: -274, Units: Celsius}).Valid() || (Temperature{Degrees: 20}).Valid() {
+		t.Error("Expected temperatures below absolute zero or without units to be invalid")
+	}
+	if celsius := (Temperature{Degrees: 77, Units: Fahrenheit}).Celsius(); math.Abs(celsius-25) > 1e-9 {
+		t.Errorf("Expected 77°F to be 25°C, got %v", celsius)
+	}
+}
+
+func TestTemperatureJSON(t *testing.T) {
+	data, _ := json.Marshal([]Temperature{{Degrees: 21.5, Units: Celsius}, {Degrees: 70}})
+	if string(data) != `["21.5°C",70]` {
+		t.Errorf("Unexpected JSON %s", data)
+	}
+
+	var temps []Temperature
+	if err := json.Unmarshal([]byte(`["21.5°C", 70, "300k"]`), &temps); err != nil {
+		t.Fatal(err)
+	}
+	if temps[0] != (Temperature{Degrees: 21.5, Units: Celsius}) || temps[1] != (Temperature{Degrees: 70}) || temps[2] != (Temperature{Degrees: 300, Units: Kelvin}) {
+		t.Errorf("Unexpected temperatures %+v", temps)
+	}
+	if err := json.Unmarshal([]byte(`true`), new(Temperature)); err == nil {
+		t.Error("Accepted a boolean temperature")
+	}
+
+	var units []TemperatureUnits
+	if err := json.Unmarshal([]byte(`["°F", "celsius", "K", ""]`), &units); err != nil {
+		t.Fatal(err)
+	}
+	if units[0] != Fahrenheit || units[1] != Celsius || units[2] != Kelvin || units[3] != "" {
+		t.Errorf("Unexpected units %v", units)
+	}
+	if err := json.Unmarshal([]byte(`"Rankine"`), new(TemperatureUnits)); err == nil {
+		t.Error("Accepted unknown units")
+	}
+}
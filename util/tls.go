@@ -0,0 +1,82 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+)
+
+// TLSConfig points at the PEM encoded certificates used to serve or call an API over TLS.
+type TLSConfig struct {
+	// Cert and Key are the certificate presented to the other side: the server certificate when serving, the client
+	// certificate when calling.
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+	// CA is the certificate authority the other side must be signed by.  When serving this requires clients to present
+	// a certificate (mutual TLS), when calling it replaces the system roots.
+	CA string `json:"ca"`
+}
+
+// ServerConfig returns the tls.Config to serve with or nil if no certificate is configured.
+func (c *TLSConfig) ServerConfig() (*tls.Config, error) {
+	if c.Cert == "" && c.Key == "" {
+		if c.CA != "" {
+			return nil, errors.New("a client CA needs a server certificate and key")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	if c.CA != "" {
+		if config.ClientCAs, err = loadCertPool(c.CA); err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// ClientConfig returns the tls.Config to call a server with or nil if nothing is configured.
+func (c *TLSConfig) ClientConfig() (*tls.Config, error) {
+	if c.Cert == "" && c.Key == "" && c.CA == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.Cert != "" || c.Key != "" {
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.CA != "" {
+		var err error
+		if config.RootCAs, err = loadCertPool(c.CA); err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in " + path)
+	}
+	return pool, nil
+}
@@ -1,27 +1,22 @@
 package util
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"sync"
 	"time"
 
 	"github.com/alittlebrighter/thermostat/controller"
 )
 
-type TemperatureUnits string
-
-const (
-	Celsius    TemperatureUnits = "Celsius"
-	Fahrenheit                  = "Fahrenheit"
-)
-
-// TempCToF converts temperature degrees from Celsius to Fahrenheit
-func TempCToF(tempC float64) float64 {
-	return tempC*9/5 + 32
-}
-
-// TempFToC converts temperature degrees from Fahrenheit to Celsius
-func TempFToC(tempF float64) float64 {
-	return (tempF - 32) * 5 / 9
+// NewID returns a random identifier that is unique enough for the handful of objects a thermostat keeps.
+func NewID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
 }
 
 type ClockTime time.Time
@@ -52,13 +47,58 @@ func (t ClockTime) AppendFormat(dat []byte, format string) []byte {
 	return time.Time(t).AppendFormat(dat, format)
 }
 
+// EventLog records a reading or something else that happened to the thermostat.  It is marshaled with the temperatures
+// as numbers and their units alongside.
 type EventLog struct {
-	AmbientTemperature float64                    `json:"ambientTemperature"`
+	AmbientTemperature Temperature
+	// RawTemperature is the reading before its thermometer's calibration and smoothing, if it has any.
+	RawTemperature *Temperature
+	Direction      controller.ThermoDirection
+	Message        string
+}
+
+// eventLogJSON is how an EventLog is marshaled.
+type eventLogJSON struct {
+	AmbientTemperature Temperature                `json:"ambientTemperature"`
+	RawTemperature     *Temperature               `json:"rawTemperature,omitempty"`
 	Units              TemperatureUnits           `json:"units"`
 	Direction          controller.ThermoDirection `json:"direction"`
+	Message            string                     `json:"message,omitempty"`
+}
+
+func (e EventLog) MarshalJSON() ([]byte, error) {
+	units := e.AmbientTemperature.Units
+	event := &eventLogJSON{AmbientTemperature: Temperature{Degrees: e.AmbientTemperature.Degrees}, Units: units, Direction: e.Direction, Message: e.Message}
+	if e.RawTemperature != nil {
+		raw := *e.RawTemperature
+		if raw.Units != "" && units != "" {
+			raw = raw.In(units)
+		}
+		event.RawTemperature = &Temperature{Degrees: raw.Degrees}
+	}
+	return json.Marshal(event)
+}
+
+// UnmarshalJSON takes the temperatures as numbers in units or as strings with units of their own, e.g. "20.5°C".
+func (e *EventLog) UnmarshalJSON(data []byte) error {
+	event := new(eventLogJSON)
+	if err := json.Unmarshal(data, event); err != nil {
+		return err
+	}
+
+	*e = EventLog{AmbientTemperature: event.AmbientTemperature, RawTemperature: event.RawTemperature, Direction: event.Direction, Message: event.Message}
+	if e.AmbientTemperature.Units == "" {
+		e.AmbientTemperature.Units = event.Units
+	}
+	if e.RawTemperature != nil && e.RawTemperature.Units == "" {
+		e.RawTemperature.Units = event.Units
+	}
+	return nil
 }
 
+// RingBuffer keeps the most recent EventLogs.  It is safe for concurrent use.
 type RingBuffer struct {
+	mu     sync.RWMutex
 	buffer []*EventLog
 	index  uint
 }
@@ -68,6 +108,9 @@ func NewRingBuffer(size uint) *RingBuffer {
 }
 
 func (buf *RingBuffer) Add(item *EventLog) {
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
 	if buf.index == uint(len(buf.buffer)) {
 		buf.index = 0
 	}
@@ -76,10 +119,18 @@ func (buf *RingBuffer) Add(item *EventLog) {
 }
 
 func (buf *RingBuffer) GetAll() []*EventLog {
-	return append(buf.buffer[buf.index:], buf.buffer[:buf.index]...)
+	buf.mu.RLock()
+	defer buf.mu.RUnlock()
+
+	all := make([]*EventLog, 0, len(buf.buffer))
+	all = append(all, buf.buffer[buf.index:]...)
+	return append(all, buf.buffer[:buf.index]...)
 }
 
 func (buf *RingBuffer) GetLast() *EventLog {
+	buf.mu.RLock()
+	defer buf.mu.RUnlock()
+
 	if buf.index == 0 {
 		return buf.buffer[len(buf.buffer)-1]
 	}
@@ -0,0 +1,37 @@
+package util
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/alittlebrighter/thermostat/controller"
+)
+
+func TestEventLogJSON(t *testing.T) {
+	raw := Temperature{Degrees: 20, Units: Celsius}
+	event := &EventLog{AmbientTemperature: Temperature{Degrees: 66.5, Units: Fahrenheit}, RawTemperature: &raw, Direction: controller.Heating}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"ambientTemperature":66.5,"rawTemperature":68,"units":"Fahrenheit","direction":"heating"}` {
+		t.Errorf("Unexpected JSON %s", data)
+	}
+
+	decoded := new(EventLog)
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.AmbientTemperature != event.AmbientTemperature || decoded.RawTemperature == nil ||
+		*decoded.RawTemperature != (Temperature{Degrees: 68, Units: Fahrenheit}) || decoded.Direction != controller.Heating {
+		t.Errorf("Event changed in a round trip: %+v", decoded)
+	}
+
+	if err := json.Unmarshal([]byte(`{"ambientTemperature": "21.5°C", "units": "F"}`), decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.AmbientTemperature != (Temperature{Degrees: 21.5, Units: Celsius}) {
+		t.Errorf("Expected a temperature with units of its own kept, got %+v", decoded.AmbientTemperature)
+	}
+}